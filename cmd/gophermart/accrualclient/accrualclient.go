@@ -1,17 +1,27 @@
 package accrualclient
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	conf "github.com/N0rkton/gophermart/internal/config"
+	"github.com/N0rkton/gophermart/internal/logger"
+	"github.com/N0rkton/gophermart/internal/metrics"
+	"github.com/N0rkton/gophermart/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
-	"log"
 	"net/http"
 	"time"
 )
 
+var (
+	log    = logger.For("accrualclient")
+	tracer = tracing.Tracer("github.com/N0rkton/gophermart/cmd/gophermart/accrualclient")
+)
+
 type AccrualClient interface {
-	GetOrder(orderNumber string) (Order, error)
+	GetOrder(ctx context.Context, orderNumber string) (Order, error)
 }
 type Order struct {
 	OrderID string  `json:"order"`
@@ -20,30 +30,44 @@ type Order struct {
 }
 type accrualClient struct {
 	accrualAddr string // -> http://accrualdomain.com/api/orders
+	client      *http.Client
 }
 
 func NewAC() AccrualClient {
-	return &accrualClient{accrualAddr: conf.GetAccrualAddress()}
+	return &accrualClient{
+		accrualAddr: conf.GetAccrualAddress(),
+		client:      &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+	}
 }
-func (ac *accrualClient) GetOrder(orderNumber string) (Order, error) {
+func (ac *accrualClient) GetOrder(ctx context.Context, orderNumber string) (order Order, err error) {
+	ctx, span := tracer.Start(ctx, "accrual.GetOrder")
+	span.SetAttributes(attribute.String("order.id", orderNumber))
+	defer func() { tracing.End(span, err) }()
 
 	url := ac.accrualAddr + "/api/orders/" + orderNumber
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Order{}, err
+	}
+	resp, err := ac.client.Do(req)
 	if err != nil {
-		log.Println(err)
+		metrics.AccrualResponse(0)
+		log.WarnContext(ctx, "accrual request failed", "order", orderNumber, "err", err)
 		return Order{}, err
 	}
+	metrics.AccrualResponse(resp.StatusCode)
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 	if resp.StatusCode == http.StatusOK {
-		defer resp.Body.Close()
 		payload, err := io.ReadAll(resp.Body)
 		if err != nil {
-			log.Println(err)
+			log.WarnContext(ctx, "read accrual response", "order", orderNumber, "err", err)
 			return Order{}, err
 		}
 		var tmp Order
 		err = json.Unmarshal(payload, &tmp)
 		if err != nil {
-			log.Println(err)
+			log.WarnContext(ctx, "decode accrual response", "order", orderNumber, "err", err)
 			return Order{}, err
 		}
 		return tmp, nil
@@ -1,19 +1,42 @@
 package main
 
 import (
+	"context"
+
 	"github.com/N0rkton/gophermart/cmd/gophermart/accrualclient"
+	"github.com/N0rkton/gophermart/internal/compress"
 	"github.com/N0rkton/gophermart/internal/config"
 	"github.com/N0rkton/gophermart/internal/datamodels"
 	"github.com/N0rkton/gophermart/internal/handlers"
+	"github.com/N0rkton/gophermart/internal/logger"
+	"github.com/N0rkton/gophermart/internal/metrics"
+	"github.com/N0rkton/gophermart/internal/outbox"
+	"github.com/N0rkton/gophermart/internal/ratelimit"
 	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/tracing"
+	"github.com/N0rkton/gophermart/internal/webhook"
 	"github.com/gorilla/mux"
-	"log"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel/attribute"
 	"net/http"
+	"os"
 	"time"
 )
 
+var (
+	log    = logger.For("main")
+	tracer = tracing.Tracer("github.com/N0rkton/gophermart/cmd/gophermart")
+)
+
+const expiryBatch = 500
+
 func main() {
 	ws := handlers.Init()
+	shutdownTracing, err := tracing.Init(context.Background(), config.GetTraceExporter())
+	if err != nil {
+		log.Error("tracing init failed", "err", err)
+		os.Exit(1)
+	}
 	ac := accrualclient.NewAC()
 	ticker := time.NewTicker(5 * time.Second)
 	go func() {
@@ -21,38 +44,201 @@ func main() {
 			orders(ws.DB, ac)
 		}
 	}()
+	go purgeDeletedUsers(ws.DB, config.GetDeletionGrace())
+	go expirePoints(ws.DB)
+	go recalculateTiers(ws.DB)
+	go expireHolds(ws.DB)
+	go webhook.NewDispatcher(ws.DB, webhook.DefaultConfig).Run(context.Background())
+	sinks, err := outbox.ParseSinks(config.GetOutboxSinks())
+	if err != nil {
+		log.Error("outbox sinks", "err", err)
+		os.Exit(1)
+	}
+	go outbox.NewRelay(ws.DB, time.Second, sinks...).Run(context.Background())
+	rateLimits, rateLimitBackend := config.GetRateLimits()
+	limits, err := ratelimit.ParseLimits(rateLimits)
+	if err != nil {
+		log.Error("rate limits", "err", err)
+		os.Exit(1)
+	}
+	backend, err := ratelimit.NewBackend(rateLimitBackend, ws.DB)
+	if err != nil {
+		log.Error("rate limit backend", "err", err)
+		os.Exit(1)
+	}
+	limiter := ratelimit.New(backend, limits, ws.RateLimitClient)
 	router := mux.NewRouter()
+	router.Use(otelmux.Middleware(tracing.ServiceName), metrics.Middleware, limiter.Middleware)
 	router.HandleFunc("/api/user/register", ws.Register).Methods(http.MethodPost)
 	router.HandleFunc("/api/user/login", ws.Login).Methods(http.MethodPost)
-	router.HandleFunc("/api/user/orders", ws.OrdersPost).Methods(http.MethodPost)
-	router.HandleFunc("/api/user/balance/withdraw", ws.Withdraw).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/login/2fa", ws.LoginSecondFactor).Methods(http.MethodPost)
+	router.HandleFunc("/api/user", ws.DeleteAccount).Methods(http.MethodDelete)
+	router.HandleFunc("/api/user/profile", ws.GetProfile).Methods(http.MethodGet)
+	router.HandleFunc("/api/user/profile", ws.UpdateProfile).Methods(http.MethodPatch)
+	router.HandleFunc("/api/user/profile/email/verify", ws.VerifyEmail).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/export", ws.ExportAccount).Methods(http.MethodGet)
+	router.HandleFunc("/api/user/password", ws.ChangePassword).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/password/reset-request", ws.RequestPasswordReset).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/password/reset", ws.ResetPassword).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/2fa/enroll", ws.TwoFactorEnroll).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/2fa/enable", ws.TwoFactorEnable).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/2fa/disable", ws.TwoFactorDisable).Methods(http.MethodPost)
+	router.Handle("/api/user/orders", ws.Scope(datamodels.ScopeOrdersWrite, ws.OrdersPost)).Methods(http.MethodPost)
+	router.Handle("/api/user/orders/batch", ws.Scope(datamodels.ScopeOrdersWrite, ws.OrdersPostBatch)).Methods(http.MethodPost)
+	router.Handle("/api/user/balance/withdraw", ws.Scope(datamodels.ScopeWithdraw, ws.Withdraw)).Methods(http.MethodPost)
+
+	router.Handle("/api/user/orders", ws.Scope(datamodels.ScopeOrdersRead, ws.OrdersGet)).Methods(http.MethodGet)
+	router.Handle("/api/user/orders/events", ws.Scope(datamodels.ScopeOrdersRead, ws.OrderEvents)).Methods(http.MethodGet)
+	router.Handle("/api/user/balance", ws.Scope(datamodels.ScopeBalanceRead, ws.Balance)).Methods(http.MethodGet)
+	router.HandleFunc("/api/user/balance/transfer", ws.Transfer).Methods(http.MethodPost)
+	router.Handle("/api/user/balance/transfers", ws.Scope(datamodels.ScopeBalanceRead, ws.Transfers)).Methods(http.MethodGet)
+	router.Handle("/api/user/balance/holds", ws.Scope(datamodels.ScopeWithdraw, ws.CreateHold)).Methods(http.MethodPost)
+	router.Handle("/api/user/balance/holds", ws.Scope(datamodels.ScopeBalanceRead, ws.Holds)).Methods(http.MethodGet)
+	router.Handle("/api/user/balance/holds/{id:[0-9]+}/capture", ws.Scope(datamodels.ScopeWithdraw, ws.CaptureHold)).Methods(http.MethodPost)
+	router.Handle("/api/user/balance/holds/{id:[0-9]+}/cancel", ws.Scope(datamodels.ScopeWithdraw, ws.CancelHold)).Methods(http.MethodPost)
+	router.Handle("/api/user/tier", ws.Scope(datamodels.ScopeBalanceRead, ws.Tier)).Methods(http.MethodGet)
+	router.Handle("/api/user/withdrawals", ws.Scope(datamodels.ScopeBalanceRead, ws.Withdrawals)).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/user/keys", ws.CreateAPIKey).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/keys", ws.ListAPIKeys).Methods(http.MethodGet)
+	router.HandleFunc("/api/user/keys/{id:[0-9]+}", ws.DeleteAPIKey).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/user/webhooks", ws.CreateWebhook).Methods(http.MethodPost)
+	router.HandleFunc("/api/user/webhooks", ws.ListWebhooks).Methods(http.MethodGet)
+	router.HandleFunc("/api/user/webhooks/{id:[0-9]+}", ws.DeleteWebhook).Methods(http.MethodDelete)
+	router.HandleFunc("/api/user/webhooks/deliveries", ws.ListWebhookDeliveries).Methods(http.MethodGet)
+	router.HandleFunc("/api/user/webhooks/deliveries/{id:[0-9]+}/replay", ws.ReplayWebhookDelivery).Methods(http.MethodPost)
 
-	router.HandleFunc("/api/user/orders", ws.OrdersGet).Methods(http.MethodGet)
-	router.HandleFunc("/api/user/balance", ws.Balance).Methods(http.MethodGet)
-	router.HandleFunc("/api/user/withdrawals", ws.Withdrawals).Methods(http.MethodGet)
+	// registered ahead of the admin subrouter: partner accounts are not staff and check their own role
+	router.Handle("/api/admin/withdrawals/{number:[0-9]+}/refunds", ws.Scope(datamodels.ScopeRefund, ws.AdminRefundWithdrawal)).Methods(http.MethodPost)
+	admin := router.PathPrefix("/api/admin").Subrouter()
+	admin.Use(ws.RequireStaff)
+	admin.HandleFunc("/users", ws.AdminFindUsers).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id:[0-9]+}", ws.AdminGetUser).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id:[0-9]+}/orders", ws.AdminUserOrders).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id:[0-9]+}/withdrawals", ws.AdminUserWithdrawals).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id:[0-9]+}/balance", ws.AdminUserBalance).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id:[0-9]+}/adjustments", ws.RequireAdmin(ws.AdminAdjustBalance)).Methods(http.MethodPost)
+	admin.HandleFunc("/users/{id:[0-9]+}/block", ws.AdminBlockUser).Methods(http.MethodPost)
+	admin.HandleFunc("/users/{id:[0-9]+}/unblock", ws.RequireAdmin(ws.AdminUnblockUser)).Methods(http.MethodPost)
+	admin.HandleFunc("/orders/{number:[0-9]+}/requeue", ws.AdminRequeueOrder).Methods(http.MethodPost)
+	admin.HandleFunc("/orders/{number:[0-9]+}/invalidate", ws.AdminInvalidateOrder).Methods(http.MethodPost)
+	admin.HandleFunc("/audit", ws.AdminAudit).Methods(http.MethodGet)
+	admin.HandleFunc("/audit/verify", ws.AdminAuditVerify).Methods(http.MethodGet)
 
-	log.Fatal(http.ListenAndServe(config.GetServerAddress(), ws.GzipHandle(router)))
+	if addr := config.GetMetricsAddress(); addr != "" {
+		go func() {
+			log.Error("metrics listener stopped", "err", http.ListenAndServe(addr, metrics.Handler()))
+			os.Exit(1)
+		}()
+	} else {
+		router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	}
 
+	handler := logger.RequestID(logger.AccessLog(compress.Decompress(compress.Compress(compress.DefaultOptions)(ws.Authenticate(router)))))
+	log.Error("server stopped", "err", http.ListenAndServe(config.GetServerAddress(), handler))
+	shutdownTracing(context.Background())
+	os.Exit(1)
 }
 func orders(db storage.Storage, ac accrualclient.AccrualClient) {
-
-	allOrders, err := db.GetAllOrdersForAccrual()
+	start := time.Now()
+	ctx, span := tracer.Start(context.Background(), "accrual.poll")
+	defer span.End()
+	if counts, err := db.CountOrdersByStatus(ctx); err == nil {
+		metrics.SetOrdersByStatus(counts)
+	}
+	allOrders, err := db.GetAllOrdersForAccrual(ctx)
 	if err != nil {
-		log.Println(err)
+		log.ErrorContext(ctx, "get orders for accrual", "err", err)
 		return
 	}
+	span.SetAttributes(attribute.Int("poll.batch_size", len(allOrders)))
+	defer metrics.ObservePoll(len(allOrders), start)
 	if allOrders == nil {
 		return
 	}
 	for _, v := range allOrders {
-		var order accrualclient.Order
-		order, err = ac.GetOrder(v)
+		processOrder(ctx, db, ac, v)
+	}
+}
 
-		if err == nil {
-			db.UpdateAccrual(datamodels.Accrual{Order: order.OrderID, Accrual: order.Accrual, Status: order.Status})
+func processOrder(ctx context.Context, db storage.Storage, ac accrualclient.AccrualClient, orderID string) {
+	ctx, span := tracer.Start(ctx, "accrual.process_order")
+	span.SetAttributes(attribute.String("order.id", orderID))
+	order, err := ac.GetOrder(ctx, orderID)
+	if err == nil {
+		_, err = db.UpdateAccrual(ctx, datamodels.Accrual{Order: order.OrderID, Accrual: order.Accrual, Status: order.Status})
+	}
+	if err != nil {
+		log.WarnContext(ctx, "accrual check failed", "order", orderID, "err", err)
+	}
+	tracing.End(span, err)
+}
+
+// purgeDeletedUsers hard-deletes accounts whose deletion grace period has passed, once an hour.
+func purgeDeletedUsers(db storage.Storage, grace time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	for ; ; <-ticker.C {
+		ctx, span := tracer.Start(context.Background(), "accounts.purge")
+		n, err := db.PurgeDeletedUsers(ctx, grace)
+		if err != nil {
+			log.ErrorContext(ctx, "purge deleted users", "err", err)
+		} else if n > 0 {
+			log.InfoContext(ctx, "purged deleted users", "count", n)
+		}
+		tracing.End(span, err)
+	}
+}
+
+// expirePoints writes off unspent points past their expiry date, once an hour.
+func expirePoints(db storage.Storage) {
+	ticker := time.NewTicker(time.Hour)
+	for ; ; <-ticker.C {
+		ctx, span := tracer.Start(context.Background(), "points.expire")
+		var total float64
+		var err error
+		for {
+			var n float64
+			if n, err = db.ExpirePoints(ctx, expiryBatch); err != nil || n == 0 {
+				break
+			}
+			total += n
 		}
 		if err != nil {
-			log.Println(err)
+			log.ErrorContext(ctx, "expire points", "err", err)
+		} else if total > 0 {
+			log.InfoContext(ctx, "expired points", "points", total)
+		}
+		tracing.End(span, err)
+	}
+}
+
+// recalculateTiers moves users between loyalty tiers by their rolling accruals, once a day.
+func recalculateTiers(db storage.Storage) {
+	ticker := time.NewTicker(24 * time.Hour)
+	for ; ; <-ticker.C {
+		ctx, span := tracer.Start(context.Background(), "tiers.recalculate")
+		n, err := db.RecalculateTiers(ctx)
+		if err != nil {
+			log.ErrorContext(ctx, "recalculate tiers", "err", err)
+		} else if n > 0 {
+			log.InfoContext(ctx, "tiers changed", "count", n)
+		}
+		tracing.End(span, err)
+	}
+}
+
+// expireHolds releases holds that were neither captured nor cancelled in time, once a minute.
+func expireHolds(db storage.Storage) {
+	ticker := time.NewTicker(time.Minute)
+	for ; ; <-ticker.C {
+		ctx, span := tracer.Start(context.Background(), "holds.expire")
+		n, err := db.ExpireHolds(ctx)
+		if err != nil {
+			log.ErrorContext(ctx, "expire holds", "err", err)
+		} else if n > 0 {
+			log.InfoContext(ctx, "expired holds", "count", n)
 		}
+		tracing.End(span, err)
 	}
 }
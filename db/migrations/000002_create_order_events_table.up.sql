@@ -0,0 +1,11 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS order_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id int NOT NULL references users(id),
+    event_type varchar(64) NOT NULL,
+    order_id varchar(255) NOT NULL,
+    payload jsonb NOT NULL,
+    created_at timestamp with time zone default now()
+);
+CREATE INDEX IF NOT EXISTS order_events_user_id_idx ON order_events (user_id, id);
+COMMIT;
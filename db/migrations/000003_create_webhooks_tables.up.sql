@@ -0,0 +1,26 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id SERIAL PRIMARY KEY,
+    user_id int NOT NULL references users(id) ON DELETE CASCADE,
+    url text NOT NULL,
+    secret varchar(64) NOT NULL,
+    event_types text[] NOT NULL,
+    active boolean NOT NULL default true,
+    created_at timestamp with time zone default now()
+);
+CREATE INDEX IF NOT EXISTS webhook_endpoints_user_id_idx ON webhook_endpoints (user_id);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    endpoint_id int NOT NULL references webhook_endpoints(id) ON DELETE CASCADE,
+    event_id bigint NOT NULL references order_events(id),
+    event_type varchar(64) NOT NULL,
+    payload jsonb NOT NULL,
+    status varchar(16) NOT NULL default 'PENDING',
+    attempts int NOT NULL default 0,
+    next_attempt_at timestamp with time zone NOT NULL default now(),
+    last_error text NOT NULL default '',
+    created_at timestamp with time zone default now(),
+    delivered_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+COMMIT;
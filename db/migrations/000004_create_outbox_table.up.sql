@@ -0,0 +1,12 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type varchar(64) NOT NULL,
+    aggregate_id varchar(255) NOT NULL,
+    user_id int NOT NULL,
+    payload jsonb NOT NULL,
+    occurred_at timestamp with time zone NOT NULL default now(),
+    published_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN ;
+DROP TABLE IF EXISTS balance_adjustments;
+ALTER TABLE users DROP COLUMN IF EXISTS created_at;
+ALTER TABLE users DROP COLUMN IF EXISTS blocked;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+COMMIT ;
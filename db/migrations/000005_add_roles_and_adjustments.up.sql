@@ -0,0 +1,14 @@
+BEGIN;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role varchar(16) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at timestamp with time zone default now();
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id SERIAL PRIMARY KEY,
+    user_id int NOT NULL references users(id),
+    admin_id int references users(id) ON DELETE SET NULL,
+    amount int NOT NULL,
+    reason text NOT NULL,
+    created_at timestamp with time zone default now()
+);
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_id_idx ON balance_adjustments (user_id);
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN ;
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+COMMIT ;
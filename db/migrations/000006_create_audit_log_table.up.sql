@@ -0,0 +1,26 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id int,
+    action varchar(64) NOT NULL,
+    target varchar(255) NOT NULL default '',
+    ip varchar(64) NOT NULL default '',
+    user_agent text NOT NULL default '',
+    request_id varchar(128) NOT NULL default '',
+    before jsonb,
+    after jsonb,
+    created_at timestamp with time zone NOT NULL,
+    prev_hash char(64) NOT NULL,
+    hash char(64) NOT NULL UNIQUE
+);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_action_idx ON audit_log (action, id);
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS auth_attempts (
+    key varchar(320) PRIMARY KEY,
+    failures int NOT NULL default 0,
+    last_failure_at timestamp with time zone NOT NULL default now(),
+    locked_until timestamp with time zone
+);
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key varchar(400) PRIMARY KEY,
+    tokens double precision NOT NULL,
+    updated_at timestamp with time zone NOT NULL default now()
+);
+COMMIT;
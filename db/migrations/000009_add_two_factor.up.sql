@@ -0,0 +1,12 @@
+BEGIN;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret varchar(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step bigint;
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id serial PRIMARY KEY,
+    user_id int NOT NULL references users(id) ON DELETE CASCADE,
+    code_hash varchar(64) NOT NULL,
+    used_at timestamp with time zone,
+    UNIQUE (user_id, code_hash)
+);
+COMMIT;
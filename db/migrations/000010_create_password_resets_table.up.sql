@@ -0,0 +1,11 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS password_resets (
+    id serial PRIMARY KEY,
+    user_id int NOT NULL references users(id) ON DELETE CASCADE,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    created_at timestamp with time zone NOT NULL default now(),
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets (user_id);
+COMMIT;
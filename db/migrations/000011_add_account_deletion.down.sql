@@ -0,0 +1,15 @@
+BEGIN ;
+-- Rows detached from purged users cannot be attributed again.
+DELETE FROM balance_adjustments WHERE user_id IS NULL;
+ALTER TABLE balance_adjustments ALTER COLUMN user_id SET NOT NULL;
+ALTER TABLE balance_adjustments DROP CONSTRAINT IF EXISTS balance_adjustments_user_id_fkey,
+    ADD CONSTRAINT balance_adjustments_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+DELETE FROM order_events WHERE user_id IS NULL;
+ALTER TABLE order_events ALTER COLUMN user_id SET NOT NULL;
+ALTER TABLE order_events DROP CONSTRAINT IF EXISTS order_events_user_id_fkey,
+    ADD CONSTRAINT order_events_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE balance DROP CONSTRAINT IF EXISTS balance_user_id_fkey,
+    ADD CONSTRAINT balance_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+DROP INDEX IF EXISTS users_deleted_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+COMMIT ;
@@ -0,0 +1,12 @@
+BEGIN;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+ALTER TABLE balance DROP CONSTRAINT IF EXISTS balance_user_id_fkey,
+    ADD CONSTRAINT balance_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE order_events ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE order_events DROP CONSTRAINT IF EXISTS order_events_user_id_fkey,
+    ADD CONSTRAINT order_events_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE balance_adjustments ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE balance_adjustments DROP CONSTRAINT IF EXISTS balance_adjustments_user_id_fkey,
+    ADD CONSTRAINT balance_adjustments_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+COMMIT;
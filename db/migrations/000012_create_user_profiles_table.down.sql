@@ -0,0 +1,4 @@
+BEGIN ;
+DROP TABLE IF EXISTS email_verifications;
+DROP TABLE IF EXISTS user_profiles;
+COMMIT ;
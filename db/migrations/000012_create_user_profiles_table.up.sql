@@ -0,0 +1,25 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS user_profiles (
+    user_id int PRIMARY KEY references users(id) ON DELETE CASCADE,
+    display_name varchar(100) NOT NULL default '',
+    email varchar(320),
+    email_verified boolean NOT NULL default false,
+    phone varchar(16),
+    locale varchar(8) NOT NULL default '',
+    notify_email boolean NOT NULL default true,
+    notify_sms boolean NOT NULL default false,
+    notify_marketing boolean NOT NULL default false,
+    updated_at timestamp with time zone NOT NULL default now()
+);
+-- Only verified addresses are unique, so nobody can reserve an address they do not control.
+CREATE UNIQUE INDEX IF NOT EXISTS user_profiles_verified_email_idx ON user_profiles (lower(email)) WHERE email_verified;
+CREATE INDEX IF NOT EXISTS user_profiles_email_idx ON user_profiles (lower(email));
+CREATE TABLE IF NOT EXISTS email_verifications (
+    id serial PRIMARY KEY,
+    user_id int NOT NULL references users(id) ON DELETE CASCADE,
+    email varchar(320) NOT NULL,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+COMMIT;
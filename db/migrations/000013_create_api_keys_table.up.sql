@@ -0,0 +1,14 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS api_keys (
+    id serial PRIMARY KEY,
+    user_id int NOT NULL references users(id) ON DELETE CASCADE,
+    name varchar(100) NOT NULL,
+    prefix varchar(16) NOT NULL UNIQUE,
+    key_hash char(64) NOT NULL,
+    scopes text[] NOT NULL,
+    created_at timestamp with time zone NOT NULL default now(),
+    last_used_at timestamp with time zone,
+    expires_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+COMMIT;
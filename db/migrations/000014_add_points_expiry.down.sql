@@ -0,0 +1,9 @@
+BEGIN ;
+DELETE FROM balance WHERE kind = 'expiration';
+DROP INDEX IF EXISTS balance_expiry_idx;
+DROP INDEX IF EXISTS balance_user_kind_idx;
+ALTER TABLE balance DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE balance DROP COLUMN IF EXISTS remaining;
+ALTER TABLE balance ALTER COLUMN order_id SET NOT NULL;
+ALTER TABLE balance DROP COLUMN IF EXISTS kind;
+COMMIT ;
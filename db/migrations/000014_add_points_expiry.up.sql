@@ -0,0 +1,23 @@
+BEGIN;
+ALTER TABLE balance ADD COLUMN IF NOT EXISTS kind varchar(16) NOT NULL DEFAULT 'order';
+UPDATE balance SET kind = 'withdrawal' WHERE accrual < 0;
+ALTER TABLE balance ALTER COLUMN order_id DROP NOT NULL;
+ALTER TABLE balance ADD COLUMN IF NOT EXISTS remaining int NOT NULL DEFAULT 0;
+ALTER TABLE balance ADD COLUMN IF NOT EXISTS expires_at timestamp with time zone;
+-- credits posted before the policy keep their points forever; what was spent since, net of
+-- adjustments, is taken from the oldest credits first, as consumption does from now on
+WITH credits AS (
+    SELECT id, user_id, accrual,
+        sum(accrual) OVER (PARTITION BY user_id ORDER BY created_at, id) AS upto,
+        sum(accrual) OVER (PARTITION BY user_id) AS total
+    FROM balance WHERE kind = 'order' AND order_status = 'PROCESSED' AND accrual > 0 AND user_id IS NOT NULL
+), balances AS (
+    SELECT b.user_id, sum(b.accrual) + coalesce((SELECT sum(a.amount) FROM balance_adjustments a WHERE a.user_id = b.user_id), 0) AS cents
+    FROM balance b WHERE b.order_status = 'PROCESSED' AND b.user_id IS NOT NULL GROUP BY b.user_id
+)
+UPDATE balance SET remaining = greatest(0, least(c.accrual, c.upto - (c.total - greatest(0, least(c.total, u.cents)))))
+FROM credits c JOIN balances u ON u.user_id = c.user_id
+WHERE balance.id = c.id;
+CREATE INDEX IF NOT EXISTS balance_user_kind_idx ON balance (user_id, kind);
+CREATE INDEX IF NOT EXISTS balance_expiry_idx ON balance (expires_at) WHERE remaining > 0;
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+DROP TABLE IF EXISTS tier_history;
+ALTER TABLE users DROP COLUMN IF EXISTS tier;
+DROP TABLE IF EXISTS tiers;
+COMMIT;
@@ -0,0 +1,22 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS tiers (
+    name varchar(32) PRIMARY KEY,
+    min_accrual int NOT NULL UNIQUE,
+    multiplier numeric(4, 2) NOT NULL CHECK (multiplier > 0)
+);
+INSERT INTO tiers (name, min_accrual, multiplier) VALUES
+    ('Bronze', 0, 1.00),
+    ('Silver', 100000, 1.05),
+    ('Gold', 500000, 1.10)
+ON CONFLICT DO NOTHING;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier varchar(32) NOT NULL DEFAULT 'Bronze' references tiers(name) ON UPDATE CASCADE;
+CREATE TABLE IF NOT EXISTS tier_history (
+    id serial PRIMARY KEY,
+    user_id int NOT NULL references users(id) ON DELETE CASCADE,
+    old_tier varchar(32) NOT NULL,
+    new_tier varchar(32) NOT NULL,
+    accrued int NOT NULL,
+    changed_at timestamp with time zone NOT NULL default now()
+);
+CREATE INDEX IF NOT EXISTS tier_history_user_id_idx ON tier_history (user_id, changed_at);
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+DELETE FROM balance WHERE kind = 'transfer';
+ALTER TABLE balance DROP COLUMN IF EXISTS transfer_id;
+DROP TABLE IF EXISTS transfers;
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS transfers (
+    id serial PRIMARY KEY,
+    from_user_id int references users(id) ON DELETE SET NULL,
+    to_user_id int references users(id) ON DELETE SET NULL,
+    amount int NOT NULL CHECK (amount > 0),
+    created_at timestamp with time zone NOT NULL default now()
+);
+CREATE INDEX IF NOT EXISTS transfers_from_user_idx ON transfers (from_user_id, created_at);
+CREATE INDEX IF NOT EXISTS transfers_to_user_idx ON transfers (to_user_id, created_at);
+ALTER TABLE balance ADD COLUMN IF NOT EXISTS transfer_id int references transfers(id);
+COMMIT;
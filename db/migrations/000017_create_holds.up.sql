@@ -0,0 +1,15 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS holds (
+    id serial PRIMARY KEY,
+    user_id int NOT NULL references users(id) ON DELETE CASCADE,
+    order_id varchar(255) NOT NULL,
+    amount int NOT NULL CHECK (amount > 0),
+    status varchar(16) NOT NULL DEFAULT 'HELD',
+    created_at timestamp with time zone NOT NULL default now(),
+    expires_at timestamp with time zone NOT NULL,
+    resolved_at timestamp with time zone
+);
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_order_idx ON holds (order_id) WHERE status = 'HELD';
+CREATE INDEX IF NOT EXISTS holds_user_id_idx ON holds (user_id, status);
+CREATE INDEX IF NOT EXISTS holds_expiry_idx ON holds (expires_at) WHERE status = 'HELD';
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+DELETE FROM balance WHERE kind = 'refund';
+ALTER TABLE balance DROP COLUMN IF EXISTS refund_id;
+DROP TABLE IF EXISTS refunds;
+COMMIT;
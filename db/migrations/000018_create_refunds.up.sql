@@ -0,0 +1,14 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS refunds (
+    id serial PRIMARY KEY,
+    withdrawal_order varchar(255) NOT NULL,
+    user_id int references users(id) ON DELETE SET NULL,
+    amount int NOT NULL CHECK (amount > 0),
+    reason varchar(500) NOT NULL DEFAULT '',
+    refunded_by int references users(id) ON DELETE SET NULL,
+    created_at timestamp with time zone NOT NULL default now()
+);
+CREATE INDEX IF NOT EXISTS refunds_withdrawal_order_idx ON refunds (withdrawal_order);
+CREATE INDEX IF NOT EXISTS refunds_user_id_idx ON refunds (user_id);
+ALTER TABLE balance ADD COLUMN IF NOT EXISTS refund_id int references refunds(id);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE outbox DROP COLUMN IF EXISTS claimed_until;
+COMMIT;
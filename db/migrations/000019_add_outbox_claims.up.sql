@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until timestamp with time zone;
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS redacted_at;
+COMMIT;
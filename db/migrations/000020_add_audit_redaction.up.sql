@@ -0,0 +1,15 @@
+BEGIN;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS redacted_at timestamp with time zone;
+-- Entries stay append-only except for the one-time pseudonymisation of a purged account's
+-- target, ip and user agent; every other column, the hashes included, must stay as written.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.redacted_at IS NULL AND NEW.redacted_at IS NOT NULL
+        AND (NEW.id, NEW.actor_id, NEW.action, NEW.request_id, NEW.before, NEW.after, NEW.created_at, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM (OLD.id, OLD.actor_id, OLD.action, OLD.request_id, OLD.before, OLD.after, OLD.created_at, OLD.prev_hash, OLD.hash) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+DROP INDEX IF EXISTS balance_partner_id_idx;
+ALTER TABLE holds DROP COLUMN IF EXISTS partner_id;
+ALTER TABLE balance DROP COLUMN IF EXISTS partner_id;
+COMMIT;
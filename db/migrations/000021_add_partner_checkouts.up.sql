@@ -0,0 +1,6 @@
+BEGIN;
+-- the partner whose checkout a withdrawal or hold was made at; partners refund only those
+ALTER TABLE balance ADD COLUMN IF NOT EXISTS partner_id int references users(id) ON DELETE SET NULL;
+ALTER TABLE holds ADD COLUMN IF NOT EXISTS partner_id int references users(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS balance_partner_id_idx ON balance (partner_id) WHERE partner_id IS NOT NULL;
+COMMIT;
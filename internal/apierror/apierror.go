@@ -0,0 +1,108 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+const ContentType = "application/problem+json"
+
+// FieldError describes a problem with a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an API error with a stable machine-readable code.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+func New(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithFields returns a copy of e carrying field details.
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	cp := *e
+	cp.Fields = append(append([]FieldError(nil), e.Fields...), fields...)
+	return &cp
+}
+
+var (
+	ErrInternal     = New(http.StatusInternalServerError, "internal_error", "internal server error")
+	ErrUnauthorized = New(http.StatusUnauthorized, "unauthorized", "authentication required")
+	ErrInvalidJSON  = New(http.StatusBadRequest, "invalid_json", "request body is not valid JSON")
+	ErrInvalidBody  = New(http.StatusBadRequest, "invalid_body", "request body is invalid")
+)
+
+type entry struct {
+	target error
+	apiErr *Error
+}
+
+var (
+	mu       sync.RWMutex
+	registry []entry
+)
+
+// Register maps a sentinel error, matched with errors.Is, to its API representation.
+func Register(target error, apiErr *Error) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry = append(registry, entry{target: target, apiErr: apiErr})
+}
+
+// From resolves err to its API representation; unknown errors become ErrInternal.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, e := range registry {
+		if errors.Is(err, e.target) {
+			return e.apiErr
+		}
+	}
+	return ErrInternal
+}
+
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail"`
+	Code     string       `json:"code"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// Write renders err as an RFC 7807 problem document.
+func Write(w http.ResponseWriter, r *http.Request, err error) *Error {
+	apiErr := From(err)
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(problem{
+		Type:     "/problems/" + apiErr.Code,
+		Title:    http.StatusText(apiErr.Status),
+		Status:   apiErr.Status,
+		Detail:   apiErr.Message,
+		Code:     apiErr.Code,
+		Instance: r.URL.Path,
+		Errors:   apiErr.Fields,
+	})
+	return apiErr
+}
@@ -0,0 +1,131 @@
+package bruteforce
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Store keeps attempt counters shared by all replicas.
+type Store interface {
+	AttemptsLockedUntil(ctx context.Context, keys []string) (time.Time, error)
+	// RecordAttempt atomically refuses a locked key by returning its lockout, or counts the
+	// attempt, restarting the count after window, and locks the key for lockFor(attempts).
+	RecordAttempt(ctx context.Context, key string, window time.Duration, lockFor func(attempts int) time.Duration) (time.Time, error)
+	// ForgiveAttempt uncounts an attempt that succeeded and relaxes the lock to lockFor(attempts).
+	ForgiveAttempt(ctx context.Context, key string, lockFor func(attempts int) time.Duration) error
+	ClearAttempts(ctx context.Context, key string) error
+}
+
+// Policy describes how failures of one kind of key are throttled. From the second failure
+// within Window the key is delayed for BaseDelay, doubling up to MaxDelay; after MaxFailures
+// it is locked for Lockout.
+type Policy struct {
+	MaxFailures int
+	Window      time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lockout     time.Duration
+}
+
+// LockedError reports that a key may not be tried again before RetryAfter elapses.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return "too many attempts, retry after " + e.RetryAfter.String()
+}
+
+// RetryAfterSeconds formats the wait for the Retry-After header, rounding up.
+func (e *LockedError) RetryAfterSeconds() string {
+	return strconv.Itoa(int((e.RetryAfter + time.Second - 1) / time.Second))
+}
+
+func LoginKey(login string) string {
+	return "login:" + login
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func RegisterKey(ip string) string {
+	return "register:" + ip
+}
+
+func ResetKey(login string) string {
+	return "reset:" + login
+}
+
+func TOTPKey(userID int) string {
+	return "totp:" + strconv.Itoa(userID)
+}
+
+type Guard struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewGuard(store Store) *Guard {
+	return &Guard{store: store, now: time.Now}
+}
+
+// Check returns a *LockedError when any of keys is currently locked.
+func (g *Guard) Check(ctx context.Context, keys ...string) error {
+	until, err := g.store.AttemptsLockedUntil(ctx, keys)
+	if err != nil {
+		return err
+	}
+	if wait := until.Sub(g.now()); wait > 0 {
+		return &LockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Attempt counts an attempt of key as failed before the secret is checked and returns a
+// *LockedError when key is locked. Counting first under the row lock keeps parallel
+// requests from all passing the check before any failure is recorded; callers Reset or
+// Forgive the key when the attempt succeeds.
+func (g *Guard) Attempt(ctx context.Context, key string, p Policy) error {
+	until, err := g.store.RecordAttempt(ctx, key, p.Window, p.delay)
+	if err != nil {
+		return err
+	}
+	if wait := until.Sub(g.now()); wait > 0 {
+		return &LockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Forgive takes back an attempt of key that succeeded, for keys shared by many users such as addresses.
+func (g *Guard) Forgive(ctx context.Context, key string, p Policy) error {
+	return g.store.ForgiveAttempt(ctx, key, p.delay)
+}
+
+// Fail records a failure of key found after the fact and locks it according to p.
+func (g *Guard) Fail(ctx context.Context, key string, p Policy) error {
+	_, err := g.store.RecordAttempt(ctx, key, p.Window, p.delay)
+	return err
+}
+
+func (g *Guard) Reset(ctx context.Context, key string) error {
+	return g.store.ClearAttempts(ctx, key)
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	if p.MaxFailures > 0 && failures >= p.MaxFailures {
+		return p.Lockout
+	}
+	if failures < 2 || p.BaseDelay <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 2; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
@@ -0,0 +1,165 @@
+package bruteforce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type attempt struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// memStore mirrors storage.DBStorage: one mutex stands in for the row lock.
+type memStore struct {
+	mu  sync.Mutex
+	now time.Time
+	m   map[string]*attempt
+}
+
+func newMemStore() *memStore {
+	return &memStore{now: time.Unix(1700000000, 0), m: map[string]*attempt{}}
+}
+
+func (s *memStore) AttemptsLockedUntil(_ context.Context, keys []string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var until time.Time
+	for _, k := range keys {
+		if a, ok := s.m[k]; ok && a.lockedUntil.After(until) {
+			until = a.lockedUntil
+		}
+	}
+	return until, nil
+}
+
+func (s *memStore) RecordAttempt(_ context.Context, key string, window time.Duration, lockFor func(int) time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.m[key]
+	if !ok {
+		a = &attempt{lastFailure: s.now}
+		s.m[key] = a
+	}
+	if a.lockedUntil.After(s.now) {
+		return a.lockedUntil, nil
+	}
+	if a.lastFailure.Before(s.now.Add(-window)) {
+		a.failures = 0
+	}
+	a.failures++
+	a.lastFailure = s.now
+	a.lockedUntil = time.Time{}
+	if wait := lockFor(a.failures); wait > 0 {
+		a.lockedUntil = s.now.Add(wait)
+	}
+	return time.Time{}, nil
+}
+
+func (s *memStore) ForgiveAttempt(_ context.Context, key string, lockFor func(int) time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.m[key]
+	if !ok {
+		return nil
+	}
+	a.failures = max(a.failures-1, 0)
+	a.lockedUntil = time.Time{}
+	if wait := lockFor(a.failures); wait > 0 {
+		a.lockedUntil = a.lastFailure.Add(wait)
+	}
+	return nil
+}
+
+func (s *memStore) ClearAttempts(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+func TestAttemptParallel(t *testing.T) {
+	store := newMemStore()
+	g := NewGuard(store)
+	g.now = func() time.Time { return store.now }
+	p := Policy{MaxFailures: 5, Window: time.Hour, Lockout: time.Hour}
+
+	var passed, locked int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := g.Attempt(context.Background(), "login:alice", p)
+			var le *LockedError
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				passed++
+			case errors.As(err, &le):
+				locked++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if passed != p.MaxFailures || locked != 100-p.MaxFailures {
+		t.Fatalf("passed %d, locked %d; want %d passed", passed, locked, p.MaxFailures)
+	}
+}
+
+func TestAttemptResetAndForgive(t *testing.T) {
+	store := newMemStore()
+	g := NewGuard(store)
+	g.now = func() time.Time { return store.now }
+	ctx := context.Background()
+	p := Policy{MaxFailures: 3, Window: time.Hour, Lockout: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if err := g.Attempt(ctx, "ip:a", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Forgive(ctx, "ip:a", p); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := g.Attempt(ctx, "ip:a", p); err != nil {
+			t.Fatalf("forgiven attempt still counted: %v", err)
+		}
+	}
+	if err := g.Check(ctx, "ip:a"); err == nil {
+		t.Fatal("third counted attempt did not lock")
+	}
+	if err := g.Reset(ctx, "ip:a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check(ctx, "ip:a"); err != nil {
+		t.Fatalf("reset key still locked: %v", err)
+	}
+
+	store.now = store.now.Add(2 * time.Hour)
+	if err := g.Attempt(ctx, "ip:a", p); err != nil {
+		t.Fatal(err)
+	}
+	if f := store.m["ip:a"].failures; f != 1 {
+		t.Fatalf("failures = %d after window, want 1", f)
+	}
+}
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{MaxFailures: 5, BaseDelay: time.Second, MaxDelay: 3 * time.Second, Lockout: time.Minute}
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 3 * time.Second, time.Minute}
+	for failures, w := range want {
+		if got := p.delay(failures); got != w {
+			t.Errorf("delay(%d) = %v, want %v", failures, got, w)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+	Brotli  = "br"
+	Zstd    = "zstd"
+)
+
+// encoder is a pooled compressing writer.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// codec knows how to compress and decompress one content coding.
+type codec struct {
+	pool      sync.Pool
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (c *codec) getEncoder(w io.Writer) encoder {
+	enc := c.pool.Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func (c *codec) putEncoder(enc encoder) {
+	enc.Reset(io.Discard)
+	c.pool.Put(enc)
+}
+
+var codecs = map[string]*codec{
+	Gzip: {
+		pool: sync.Pool{New: func() any {
+			w, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+			return w
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	// HTTP deflate is the zlib format (RFC 9110, section 8.4.1.2), not a raw deflate stream.
+	Deflate: {
+		pool: sync.Pool{New: func() any {
+			w, _ := zlib.NewWriterLevel(io.Discard, zlib.BestSpeed)
+			return w
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	},
+	Brotli: {
+		pool: sync.Pool{New: func() any {
+			return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	},
+	Zstd: {
+		pool: sync.Pool{New: func() any {
+			w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+			return w
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+}
+
+// preference orders the supported codings from most to least preferred on equal q-values.
+var preference = []string{Zstd, Brotli, Gzip, Deflate}
@@ -0,0 +1,143 @@
+package compress_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/N0rkton/gophermart/internal/compress"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// coders encode and decode each coding with the reference libraries, independently of the middleware.
+var coders = map[string]struct {
+	encode func(w io.Writer) io.WriteCloser
+	decode func(r io.Reader) (io.Reader, error)
+}{
+	compress.Gzip: {
+		encode: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		decode: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	},
+	compress.Deflate: {
+		encode: func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		decode: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	},
+	compress.Brotli: {
+		encode: func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		decode: func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	},
+	compress.Zstd: {
+		encode: func(w io.Writer) io.WriteCloser {
+			enc, _ := zstd.NewWriter(w)
+			return enc
+		},
+		decode: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	},
+}
+
+// echo answers with the request body as JSON.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, r.Body)
+})
+
+func TestRoundTrip(t *testing.T) {
+	body := `{"orders":["` + strings.Repeat("12345678903", 100) + `"]}`
+	handler := compress.Decompress(compress.Compress(compress.DefaultOptions)(echo))
+	for coding, c := range coders {
+		t.Run(coding, func(t *testing.T) {
+			var req bytes.Buffer
+			enc := c.encode(&req)
+			enc.Write([]byte(body))
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", &req)
+			r.Header.Set("Content-Encoding", coding)
+			r.Header.Set("Accept-Encoding", coding)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != coding {
+				t.Fatalf("Content-Encoding %q, want %q", got, coding)
+			}
+			dec, err := c.decode(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("round trip changed the body: %q", got)
+			}
+		})
+	}
+}
+
+func TestCompressSkipsSmallBodies(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	r.Header.Set("Accept-Encoding", compress.Gzip)
+	w := httptest.NewRecorder()
+	compress.Compress(compress.DefaultOptions)(echo).ServeHTTP(w, r)
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding %q for a two-byte body", got)
+	}
+	if w.Body.String() != `{}` {
+		t.Errorf("body %q", w.Body)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip, deflate", compress.Gzip},
+		{"deflate", compress.Deflate},
+		{"gzip;q=0.5, br", compress.Brotli},
+		{"*", compress.Zstd},
+		{"zstd;q=0, *;q=0.1", compress.Brotli},
+	}
+	body := strings.Repeat("x", compress.DefaultOptions.MinSize)
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		compress.Compress(compress.DefaultOptions)(echo).ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestDecompressRejects(t *testing.T) {
+	tests := []struct {
+		encoding string
+		want     int
+	}{
+		{"compress", http.StatusUnsupportedMediaType},
+		{compress.Gzip, http.StatusBadRequest},
+		{compress.Deflate, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not compressed"))
+		r.Header.Set("Content-Encoding", tt.encoding)
+		w := httptest.NewRecorder()
+		compress.Decompress(echo).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("Content-Encoding %q: status %d, want %d", tt.encoding, w.Code, tt.want)
+		}
+	}
+}
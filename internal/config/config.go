@@ -3,24 +3,95 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //адрес и порт запуска сервиса: переменная окружения ОС RUN_ADDRESS или флаг -a;
 //адрес подключения к базе данных: переменная окружения ОС DATABASE_URI или флаг -d;
-//адрес системы расчёта начислений: переменная окружения ОС ACCRUAL_SYSTEM_ADDRESS или флаг -r.
+//адрес системы расчёта начислений: переменная окружения ОС ACCRUAL_SYSTEM_ADDRESS или флаг -r;
+//адрес отдельного слушателя метрик: переменная окружения ОС METRICS_ADDRESS или флаг -m (пусто — на основном адресе);
+//уровни логирования: переменная окружения ОС LOG_LEVEL или флаг -l, например "info,storage=debug";
+//экспорт трассировок: переменная окружения ОС TRACE_EXPORTER или флаг -t (none, stdout, file:<путь>, otlp);
+//получатели доменных событий: переменная окружения ОС OUTBOX_SINKS или флаг -o (log, file:<путь>, webhook:<url> через запятую),
+//секрет подписи для webhook: переменная окружения ОС OUTBOX_WEBHOOK_SECRET;
+//логины администраторов, получающих роль admin при запуске: переменная окружения ОС ADMIN_LOGINS или флаг -admins;
+//защита от подбора паролей: LOGIN_MAX_FAILURES (-login-max-failures) неудачных входов на логин и IP_MAX_FAILURES
+//(-ip-max-failures) на IP до блокировки на LOGIN_LOCKOUT (-login-lockout), REGISTRATIONS_PER_IP (-registrations-per-ip) в час;
+//ограничение частоты запросов: переменная окружения ОС RATE_LIMITS или флаг -rate-limits
+//(<шаблон маршрута>=<число>/<период>[:<запас>] через запятую, например "/api/user/orders=60/1m:20"),
+//хранилище счётчиков: RATE_LIMIT_BACKEND или флаг -rate-limit-backend (memory, postgres);
+//сумма списания, выше которой при включённой 2FA нужен одноразовый код: WITHDRAW_TOTP_THRESHOLD или флаг -withdraw-totp-threshold;
+//доставка уведомлений (сброс пароля): переменная окружения ОС NOTIFIER или флаг -notifier
+//(log — только запись в журнал без текста письма, file:<путь>, smtp://[user:password@]host:port?from=<адрес>[&domain=<домен>]);
+//срок до окончательного удаления удалённых аккаунтов: ACCOUNT_DELETION_GRACE или флаг -deletion-grace;
+//срок жизни начисленных баллов в месяцах: POINTS_LIFETIME_MONTHS или флаг -points-lifetime-months (0 — бессрочно),
+//за сколько до сгорания показывать баллы в балансе: POINTS_EXPIRY_NOTICE или флаг -points-expiry-notice;
+//сколько баллов можно перевести другим пользователям за сутки: TRANSFER_DAILY_LIMIT или флаг -transfer-daily-limit (0 — без ограничения);
+//время жизни резерва баллов под списание: HOLD_TTL или флаг -hold-ttl.
 
 type Cfg struct {
 	ServerAddress  string
 	DBAddress      *string
 	AccrualAddress *string
+	MetricsAddress *string
+	LogLevel       *string
+	TraceExporter  *string
+	OutboxSinks    *string
+	OutboxSecret   string
+	Admins         *string
+	BruteForce     BruteForceCfg
+	RateLimits     *string
+	RateLimitStore *string
+	TOTPThreshold  *float64
+	Notifier       *string
+	DeletionGrace  *time.Duration
+	PointsExpiry   PointsExpiryCfg
+	TransferLimit  *float64
+	HoldTTL        *time.Duration
+}
+
+type PointsExpiryCfg struct {
+	LifetimeMonths *int
+	Notice         *time.Duration
+}
+
+type BruteForceCfg struct {
+	LoginMaxFailures   *int
+	IPMaxFailures      *int
+	Lockout            *time.Duration
+	RegistrationsPerIP *int
 }
 
 var config Cfg
 
+const defaultRateLimits = "/api/user/register=10/1m:5,/api/user/login=30/1m:10,/api/user/login/2fa=30/1m:10,/api/user/orders=60/1m:20," +
+	"/api/user/orders/batch=10/1m:2,/api/user/balance/withdraw=10/1m:5,/api/user/balance/transfer=10/1m:5,/api/user/balance/holds=30/1m:10," +
+	"/api/user/password=5/1m:3,/api/user/password/reset-request=5/1h:3,/api/user/password/reset=10/1h:5,/api/user/profile/email/verify=10/1h:5"
+
 func init() {
 	config.ServerAddress = *flag.String("a", "localhost:8080", "server address")
 	config.DBAddress = flag.String("d", "", "data base connection address")
 	config.AccrualAddress = flag.String("r", "", "accrual system server address")
+	config.MetricsAddress = flag.String("m", "", "separate metrics listener address")
+	config.TraceExporter = flag.String("t", "none", "trace exporter: none, stdout, file:<path> or otlp")
+	config.OutboxSinks = flag.String("o", "log", "domain event sinks: log, file:<path>, webhook:<url>, comma separated")
+	config.Admins = flag.String("admins", "", "comma separated logins granted the admin role at startup")
+	config.BruteForce.LoginMaxFailures = flag.Int("login-max-failures", 5, "failed logins per login before lockout")
+	config.BruteForce.IPMaxFailures = flag.Int("ip-max-failures", 20, "failed logins per IP before lockout")
+	config.BruteForce.Lockout = flag.Duration("login-lockout", 15*time.Minute, "lockout after too many failed logins")
+	config.BruteForce.RegistrationsPerIP = flag.Int("registrations-per-ip", 10, "registrations allowed per IP and hour")
+	config.RateLimits = flag.String("rate-limits", defaultRateLimits, "per route rate limits: <route>=<n>/<period>[:<burst>], comma separated")
+	config.RateLimitStore = flag.String("rate-limit-backend", "memory", "rate limit backend: memory or postgres")
+	config.TOTPThreshold = flag.Float64("withdraw-totp-threshold", 1000, "withdrawals above this sum require a one-time code when 2FA is enabled")
+	config.Notifier = flag.String("notifier", "log", "notification channel: log (drops messages), file:<path> or smtp://[user:password@]host:port?from=<address>")
+	config.DeletionGrace = flag.Duration("deletion-grace", 30*24*time.Hour, "time before deleted accounts are purged")
+	config.PointsExpiry.LifetimeMonths = flag.Int("points-lifetime-months", 12, "months before credited points expire, 0 to keep them forever")
+	config.PointsExpiry.Notice = flag.Duration("points-expiry-notice", 30*24*time.Hour, "how early the balance lists points about to expire")
+	config.TransferLimit = flag.Float64("transfer-daily-limit", 1000, "points a user may transfer per 24 hours, 0 for no limit")
+	config.HoldTTL = flag.Duration("hold-ttl", 15*time.Minute, "time before an uncaptured hold is released")
+	config.LogLevel = flag.String("l", "info", "log levels: default and per package, e.g. info,storage=debug")
 }
 func NewConfig() Cfg {
 	flag.Parse()
@@ -37,14 +108,103 @@ func NewConfig() Cfg {
 	if accrualEnv != "" {
 		config.AccrualAddress = &accrualEnv
 	}
+	metricsEnv := os.Getenv("METRICS_ADDRESS")
+	if metricsEnv != "" {
+		config.MetricsAddress = &metricsEnv
+	}
+	logLevelEnv := os.Getenv("LOG_LEVEL")
+	if logLevelEnv != "" {
+		config.LogLevel = &logLevelEnv
+	}
+	traceEnv := os.Getenv("TRACE_EXPORTER")
+	if traceEnv != "" {
+		config.TraceExporter = &traceEnv
+	}
+	outboxEnv := os.Getenv("OUTBOX_SINKS")
+	if outboxEnv != "" {
+		config.OutboxSinks = &outboxEnv
+	}
+	config.OutboxSecret = os.Getenv("OUTBOX_WEBHOOK_SECRET")
+	adminsEnv := os.Getenv("ADMIN_LOGINS")
+	if adminsEnv != "" {
+		config.Admins = &adminsEnv
+	}
+	rateLimitsEnv := os.Getenv("RATE_LIMITS")
+	if rateLimitsEnv != "" {
+		config.RateLimits = &rateLimitsEnv
+	}
+	rateLimitStoreEnv := os.Getenv("RATE_LIMIT_BACKEND")
+	if rateLimitStoreEnv != "" {
+		config.RateLimitStore = &rateLimitStoreEnv
+	}
+	notifierEnv := os.Getenv("NOTIFIER")
+	if notifierEnv != "" {
+		config.Notifier = &notifierEnv
+	}
+	envDuration("ACCOUNT_DELETION_GRACE", &config.DeletionGrace)
+	envInt("POINTS_LIFETIME_MONTHS", &config.PointsExpiry.LifetimeMonths)
+	envDuration("POINTS_EXPIRY_NOTICE", &config.PointsExpiry.Notice)
+	envFloat("WITHDRAW_TOTP_THRESHOLD", &config.TOTPThreshold)
+	envFloat("TRANSFER_DAILY_LIMIT", &config.TransferLimit)
+	envDuration("HOLD_TTL", &config.HoldTTL)
+	envInt("LOGIN_MAX_FAILURES", &config.BruteForce.LoginMaxFailures)
+	envInt("IP_MAX_FAILURES", &config.BruteForce.IPMaxFailures)
+	envDuration("LOGIN_LOCKOUT", &config.BruteForce.Lockout)
+	envInt("REGISTRATIONS_PER_IP", &config.BruteForce.RegistrationsPerIP)
 	if *config.DBAddress == "" || *config.AccrualAddress == "" || config.ServerAddress == "" {
 		panic("invalid config")
 	}
 	return config
 }
+
+// envInt overrides dst with the integer environment variable name when it is set and valid.
+func envInt(name string, dst **int) {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		*dst = &v
+	}
+}
+
+// envFloat overrides dst with the numeric environment variable name when it is set and valid.
+func envFloat(name string, dst **float64) {
+	if v, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		*dst = &v
+	}
+}
+
+// envDuration overrides dst with the duration environment variable name when it is set and valid.
+func envDuration(name string, dst **time.Duration) {
+	if v, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		*dst = &v
+	}
+}
 func GetServerAddress() string {
 	return config.ServerAddress
 }
 func GetAccrualAddress() string {
 	return *config.AccrualAddress
 }
+func GetMetricsAddress() string {
+	return *config.MetricsAddress
+}
+func GetTraceExporter() string {
+	return *config.TraceExporter
+}
+func GetOutboxSinks() (string, string) {
+	return *config.OutboxSinks, config.OutboxSecret
+}
+func GetDeletionGrace() time.Duration {
+	return *config.DeletionGrace
+}
+func GetRateLimits() (string, string) {
+	return *config.RateLimits, *config.RateLimitStore
+}
+
+func (c Cfg) AdminLogins() []string {
+	var logins []string
+	for _, login := range strings.Split(*c.Admins, ",") {
+		if login = strings.TrimSpace(login); login != "" {
+			logins = append(logins, login)
+		}
+	}
+	return logins
+}
@@ -1,10 +1,35 @@
 package datamodels
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Auth struct {
 	ID       int
 	Password string
+	Blocked  bool
+}
+
+// User roles; support and admin staff may use the admin API. Partner accounts are not staff:
+// they may only refund withdrawals made at their checkout.
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+	RolePartner = "partner"
+)
+
+type User struct {
+	ID        int       `json:"id"`
+	Login     string    `json:"login"`
+	Role      string    `json:"role"`
+	Blocked   bool      `json:"blocked"`
+	CreatedAt time.Time `json:"created_at"`
+}
+type Adjustment struct {
+	Sum    float64 `json:"sum"`
+	Reason string  `json:"reason"`
 }
 type Order struct {
 	OrderID     string    `json:"number"`
@@ -12,30 +37,292 @@ type Order struct {
 	Accrual     float32   `json:"accrual,omitempty"`
 	CreatedAt   time.Time `json:"uploaded_at"`
 }
+
+// Balance.Current is what the user can spend: points held for pending withdrawals are reported in Held.
 type Balance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   float64          `json:"current"`
+	Held      float64          `json:"held"`
+	Withdrawn float64          `json:"withdrawn"`
+	Expiring  []ExpiringPoints `json:"expiring_soon,omitempty"`
+}
+
+// ExpiringPoints is the sum of points expiring on one day.
+type ExpiringPoints struct {
+	Sum       float64   `json:"sum"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 type Withdrawals struct {
 	Order       string    `json:"order"`
 	Sum         float64   `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
+	Refunded    float64   `json:"refunded,omitempty"`
+	Refunds     []Refund  `json:"refunds,omitempty"`
 }
 type OrderInfo struct {
 	UserID  int
-	OrderID int
+	OrderID string
 	Sum     float64
+	Partner string
 }
 type Reg struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
+
+// Withdraw spends points on an order; Partner names the partner account whose checkout it is paid at.
 type Withdraw struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order   string  `json:"order"`
+	Sum     float64 `json:"sum"`
+	Partner string  `json:"partner,omitempty"`
 }
 type Accrual struct {
 	Order   string  `json:"order"`
 	Status  string  `json:"status"`
 	Accrual float32 `json:"accrual"`
 }
+
+// Per-item outcomes of a batch order upload.
+const (
+	BatchAccepted        = "accepted"
+	BatchAlreadyUploaded = "already_uploaded"
+	BatchAnotherUser     = "another_user"
+	BatchInvalid         = "invalid"
+)
+
+type BatchOrderResult struct {
+	Order  string `json:"number"`
+	Status string `json:"status"`
+}
+
+// Types of order events streamed to users.
+const (
+	EventOrderStatusChanged = "order.status_changed"
+	EventWithdrawalPosted   = "withdrawal.posted"
+	EventWithdrawalRefunded = "withdrawal.refunded"
+)
+
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Order     string          `json:"order"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+type OrderStatusEvent struct {
+	Order   string  `json:"number"`
+	Status  string  `json:"status"`
+	Accrual float32 `json:"accrual,omitempty"`
+}
+type WithdrawalEvent struct {
+	Order       string    `json:"order"`
+	Sum         float64   `json:"sum"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryDead      = "DEAD"
+)
+
+type WebhookEndpoint struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"-"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	EndpointID    int             `json:"endpoint_id"`
+	EventID       int64           `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"-"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	URL           string          `json:"-"`
+	Secret        string          `json:"-"`
+}
+type NewWebhook struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// SecondFactor carries either a TOTP code or a recovery code.
+type SecondFactor struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+type PendingLogin struct {
+	Token     string `json:"mfa_token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+type LoginSecondFactor struct {
+	Token string `json:"mfa_token"`
+	SecondFactor
+}
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+type PasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type NotificationPrefs struct {
+	Email     bool `json:"email"`
+	SMS       bool `json:"sms"`
+	Marketing bool `json:"marketing"`
+}
+type Profile struct {
+	DisplayName   string            `json:"display_name"`
+	Email         string            `json:"email,omitempty"`
+	EmailVerified bool              `json:"email_verified"`
+	Phone         string            `json:"phone,omitempty"`
+	Locale        string            `json:"locale,omitempty"`
+	Notifications NotificationPrefs `json:"notifications"`
+}
+
+// ProfilePatch is a partial profile update; nil fields are left unchanged and an empty email or phone clears it.
+type ProfilePatch struct {
+	DisplayName   *string `json:"display_name"`
+	Email         *string `json:"email"`
+	Phone         *string `json:"phone"`
+	Locale        *string `json:"locale"`
+	Notifications *struct {
+		Email     *bool `json:"email"`
+		SMS       *bool `json:"sms"`
+		Marketing *bool `json:"marketing"`
+	} `json:"notifications"`
+}
+type EmailVerification struct {
+	Token string `json:"token"`
+}
+
+// API key scopes.
+const (
+	ScopeOrdersWrite = "orders:write"
+	ScopeOrdersRead  = "orders:read"
+	ScopeBalanceRead = "balance:read"
+	ScopeWithdraw    = "withdraw"
+	// ScopeRefund is granted only to keys of partner and admin accounts.
+	ScopeRefund = "refunds"
+)
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	// Key is the full secret, returned only when the key is created.
+	Key string `json:"key,omitempty"`
+}
+type NewAPIKey struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// TierStatus is the user's loyalty tier with the points accrued over the rolling window.
+type TierStatus struct {
+	Tier       string       `json:"tier"`
+	Multiplier float64      `json:"multiplier"`
+	Accrued    float64      `json:"accrued"`
+	Next       *TierNext    `json:"next,omitempty"`
+	History    []TierChange `json:"history"`
+}
+type TierNext struct {
+	Tier       string  `json:"tier"`
+	Multiplier float64 `json:"multiplier"`
+	MinAccrual float64 `json:"min_accrual"`
+	Remaining  float64 `json:"remaining"`
+}
+type TierChange struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Accrued   float64   `json:"accrued"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Transfer directions.
+const (
+	TransferIn  = "in"
+	TransferOut = "out"
+)
+
+type TransferRequest struct {
+	To  string  `json:"to"`
+	Sum float64 `json:"sum"`
+}
+type Transfer struct {
+	ID           int       `json:"id"`
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Sum          float64   `json:"sum"`
+	ProcessedAt  time.Time `json:"processed_at"`
+}
+
+// Hold statuses.
+const (
+	HoldHeld      = "HELD"
+	HoldCaptured  = "CAPTURED"
+	HoldCancelled = "CANCELLED"
+	HoldExpired   = "EXPIRED"
+)
+
+// Hold reserves points for a withdrawal until it is captured, cancelled or expires.
+type Hold struct {
+	ID         int        `json:"id"`
+	Order      string     `json:"order"`
+	Sum        float64    `json:"sum"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// RefundRequest reverses Sum points of a withdrawal, or with Full the rest of it; exactly one must be given.
+type RefundRequest struct {
+	Sum    *float64 `json:"sum,omitempty"`
+	Full   bool     `json:"full,omitempty"`
+	Reason string   `json:"reason"`
+}
+type Refund struct {
+	ID          int       `json:"id"`
+	Order       string    `json:"order"`
+	Sum         float64   `json:"sum"`
+	Reason      string    `json:"reason,omitempty"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+type RefundEvent struct {
+	Order       string    `json:"order"`
+	Sum         float64   `json:"sum"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
@@ -0,0 +1,63 @@
+// Package domain defines the events emitted when the loyalty state changes.
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	OrderUploaded      = "OrderUploaded"
+	OrderStatusChanged = "OrderStatusChanged"
+	PointsAccrued      = "PointsAccrued"
+	PointsWithdrawn    = "PointsWithdrawn"
+	BalanceAdjusted    = "BalanceAdjusted"
+	PointsExpired      = "PointsExpired"
+	PointsTransferred  = "PointsTransferred"
+	PointsRefunded     = "PointsRefunded"
+)
+
+// Event is a domain event as stored in the outbox; AggregateID is the order number.
+type Event struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregate_id"`
+	UserID      int             `json:"user_id"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+type OrderUploadedPayload struct {
+	Order string `json:"order"`
+}
+type OrderStatusChangedPayload struct {
+	Order  string `json:"order"`
+	Status string `json:"status"`
+}
+type PointsAccruedPayload struct {
+	Order  string  `json:"order"`
+	Points float64 `json:"points"`
+}
+type PointsWithdrawnPayload struct {
+	Order  string  `json:"order"`
+	Points float64 `json:"points"`
+}
+type BalanceAdjustedPayload struct {
+	Points  float64 `json:"points"`
+	Reason  string  `json:"reason"`
+	AdminID int     `json:"admin_id"`
+}
+type PointsExpiredPayload struct {
+	Order  string  `json:"order,omitempty"`
+	Points float64 `json:"points"`
+}
+type PointsTransferredPayload struct {
+	Transfer int     `json:"transfer"`
+	From     int     `json:"from"`
+	To       int     `json:"to"`
+	Points   float64 `json:"points"`
+}
+type PointsRefundedPayload struct {
+	Order  string  `json:"order"`
+	Points float64 `json:"points"`
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/logger"
+	"github.com/jackc/pgx/v5"
+)
+
+var log = logger.For("events")
+
+// Broker wakes up the streams of a user when new events are appended to their log.
+// Subscribers re-read the log themselves, so a dropped or coalesced wake-up loses nothing.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[int]map[chan struct{}]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[int]map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel signalled on new events of userID and a function releasing it.
+func (b *Broker) Subscribe(userID int) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[userID], ch)
+		if len(b.subs[userID]) == 0 {
+			delete(b.subs, userID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Broker) Notify(userID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[userID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type notification struct {
+	ID     int64 `json:"id"`
+	UserID int   `json:"user_id"`
+}
+
+// Listen relays Postgres notifications on channel to subscribers until ctx is done,
+// reconnecting after failures so events written by any replica reach local streams.
+func (b *Broker) Listen(ctx context.Context, dsn string, channel string) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := b.listen(ctx, dsn, channel)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn("event listener disconnected", "err", err, "retry_in", backoff.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (b *Broker) listen(ctx context.Context, dsn string, channel string) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err = conn.Exec(ctx, "listen "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	b.notifyAll()
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var msg notification
+		if err = json.Unmarshal([]byte(n.Payload), &msg); err != nil {
+			log.Warn("invalid event notification", "payload", n.Payload, "err", err)
+			continue
+		}
+		b.Notify(msg.UserID)
+	}
+}
+
+// notifyAll wakes every stream, e.g. after reconnecting when notifications may have been missed.
+func (b *Broker) notifyAll() {
+	b.mu.Lock()
+	users := make([]int, 0, len(b.subs))
+	for id := range b.subs {
+		users = append(users, id)
+	}
+	b.mu.Unlock()
+	for _, id := range users {
+		b.Notify(id)
+	}
+}
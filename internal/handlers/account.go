@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/apierror"
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/bruteforce"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/storage"
+)
+
+const exportAuditPage = 500
+
+var ErrExportFormat = apierror.New(http.StatusBadRequest, "invalid_format", "format must be json or csv")
+
+// Export is the personal data returned by ExportAccount.
+type Export struct {
+	Profile     datamodels.User          `json:"profile"`
+	Contact     datamodels.Profile       `json:"contact"`
+	Orders      []datamodels.Order       `json:"orders"`
+	Withdrawals []datamodels.Withdrawals `json:"withdrawals"`
+	AuditEvents []audit.Entry            `json:"audit_events"`
+	ExportedAt  time.Time                `json:"exported_at"`
+}
+
+// ExportAccount returns the user's data as a JSON document or, with format=csv, a zip of CSV files.
+func (ws wrapperStruct) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := ws.currentAccount(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, r, ErrExportFormat)
+		return
+	}
+	export, err := ws.collectExport(r, account)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	name := "gophermart-export-" + strconv.Itoa(account.ID)
+	if format == "csv" {
+		w.Header().Set("content-type", "application/zip")
+		w.Header().Set("content-disposition", `attachment; filename="`+name+`.zip"`)
+		if err = writeExportZip(w, export); err != nil {
+			log.ErrorContext(r.Context(), "writing export", "err", err)
+		}
+		return
+	}
+	w.Header().Set("content-disposition", `attachment; filename="`+name+`.json"`)
+	writeJSON(w, r, export)
+}
+
+func (ws wrapperStruct) collectExport(r *http.Request, account datamodels.User) (Export, error) {
+	export := Export{Profile: account, ExportedAt: time.Now().UTC()}
+	var err error
+	if export.Contact, err = ws.Users.GetProfile(r.Context(), account.ID); err != nil {
+		return Export{}, err
+	}
+	export.Orders, err = ws.DB.GetOrderList(r.Context(), datamodels.OrderInfo{UserID: account.ID})
+	if err != nil && !errors.Is(err, storage.ErrNoData) {
+		return Export{}, err
+	}
+	export.Withdrawals, err = ws.DB.GetWithdrawList(r.Context(), datamodels.OrderInfo{UserID: account.ID})
+	if err != nil && !errors.Is(err, storage.ErrNoData) {
+		return Export{}, err
+	}
+	f := audit.Filter{ActorID: account.ID, Limit: exportAuditPage}
+	for {
+		page, err := ws.DB.QueryAudit(r.Context(), f)
+		if errors.Is(err, storage.ErrNoData) {
+			break
+		}
+		if err != nil {
+			return Export{}, err
+		}
+		export.AuditEvents = append(export.AuditEvents, page...)
+		if len(page) < f.Limit {
+			break
+		}
+		f.BeforeID = page[len(page)-1].ID
+	}
+	return export, nil
+}
+
+func writeExportZip(w http.ResponseWriter, e Export) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		rows [][]string
+	}{
+		{"profile.csv", [][]string{
+			{"id", "login", "role", "blocked", "created_at"},
+			{strconv.Itoa(e.Profile.ID), e.Profile.Login, e.Profile.Role, strconv.FormatBool(e.Profile.Blocked), e.Profile.CreatedAt.Format(time.RFC3339)},
+		}},
+		{"contact.csv", [][]string{
+			{"display_name", "email", "email_verified", "phone", "locale", "notify_email", "notify_sms", "notify_marketing"},
+			{e.Contact.DisplayName, e.Contact.Email, strconv.FormatBool(e.Contact.EmailVerified), e.Contact.Phone, e.Contact.Locale,
+				strconv.FormatBool(e.Contact.Notifications.Email), strconv.FormatBool(e.Contact.Notifications.SMS), strconv.FormatBool(e.Contact.Notifications.Marketing)},
+		}},
+		{"orders.csv", orderRows(e.Orders)},
+		{"withdrawals.csv", withdrawalRows(e.Withdrawals)},
+		{"audit_events.csv", auditRows(e.AuditEvents)},
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err = csv.NewWriter(f).WriteAll(file.rows); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func orderRows(orders []datamodels.Order) [][]string {
+	rows := [][]string{{"number", "status", "accrual", "uploaded_at"}}
+	for _, o := range orders {
+		rows = append(rows, []string{o.OrderID, o.OrderStatus, fmt.Sprint(o.Accrual), o.CreatedAt.Format(time.RFC3339)})
+	}
+	return rows
+}
+
+func withdrawalRows(withdrawals []datamodels.Withdrawals) [][]string {
+	rows := [][]string{{"order", "sum", "processed_at"}}
+	for _, wd := range withdrawals {
+		rows = append(rows, []string{wd.Order, strconv.FormatFloat(wd.Sum, 'f', -1, 64), wd.ProcessedAt.Format(time.RFC3339)})
+	}
+	return rows
+}
+
+func auditRows(entries []audit.Entry) [][]string {
+	rows := [][]string{{"id", "action", "target", "ip", "user_agent", "before", "after", "created_at"}}
+	for _, e := range entries {
+		rows = append(rows, []string{strconv.FormatInt(e.ID, 10), e.Action, e.Target, e.IP, e.UserAgent,
+			string(e.Before), string(e.After), e.CreatedAt.Format(time.RFC3339)})
+	}
+	return rows
+}
+
+// DeleteAccount anonymises the account and ends its sessions; the row is purged after the grace period.
+// Users with two-factor authentication confirm with a code in the X-OTP-Code header.
+func (ws wrapperStruct) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := ws.currentAccount(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.requireFreshOTP(r, account.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.DB.DeleteAccount(r.Context(), account.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.guard.Reset(r.Context(), bruteforce.LoginKey(account.Login)); err != nil {
+		log.WarnContext(r.Context(), "reset login attempts", "err", err)
+	}
+	// the sessions are revoked even if the audit entry fails
+	err = errors.Join(
+		ws.audit(r, account.ID, audit.ActionAccountDelete, "", nil, nil),
+		ws.revokeSessions(r, account.ID, ""),
+	)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/validation"
+	"github.com/gorilla/mux"
+)
+
+const maxUsersListed = 50
+
+// maxAdjustment caps a single manual credit or debit, in points.
+const maxAdjustment = 100_000
+
+// roleRank orders the roles by privilege; staff may only act on accounts ranked below them.
+var roleRank = map[string]int{
+	datamodels.RoleUser:    0,
+	datamodels.RolePartner: 0,
+	datamodels.RoleSupport: 1,
+	datamodels.RoleAdmin:   2,
+}
+
+const staffKey contextKey = 1
+
+// RequireStaff lets only support and admin accounts through and stores the account in the context.
+func (ws wrapperStruct) RequireStaff(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account, err := ws.currentAccount(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if account.Role != datamodels.RoleSupport && account.Role != datamodels.RoleAdmin {
+			writeError(w, r, ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), staffKey, account)))
+	})
+}
+
+// RequireAdmin limits a staff route to admins; support accounts may look but not move money or unblock.
+func (ws wrapperStruct) RequireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if staffFrom(r).Role != datamodels.RoleAdmin {
+			writeError(w, r, ErrForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func staffFrom(r *http.Request) datamodels.User {
+	account, _ := r.Context().Value(staffKey).(datamodels.User)
+	return account
+}
+
+// adminTarget loads the user addressed by the {id} route variable.
+func (ws wrapperStruct) adminTarget(r *http.Request) (datamodels.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return datamodels.User{}, ErrUserNotFound
+	}
+	user, err := ws.DB.GetUser(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return datamodels.User{}, ErrUserNotFound
+	}
+	return user, err
+}
+
+// AdminFindUsers searches users by a login substring given in ?login=.
+func (ws wrapperStruct) AdminFindUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := ws.DB.FindUsers(r.Context(), r.URL.Query().Get("login"), maxUsersListed)
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, users)
+}
+
+func (ws wrapperStruct) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := ws.adminTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, user)
+}
+
+func (ws wrapperStruct) AdminUserOrders(w http.ResponseWriter, r *http.Request) {
+	user, err := ws.adminTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	orderList, err := ws.DB.GetOrderList(r.Context(), datamodels.OrderInfo{UserID: user.ID})
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, orderList)
+}
+
+func (ws wrapperStruct) AdminUserWithdrawals(w http.ResponseWriter, r *http.Request) {
+	user, err := ws.adminTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	withdrawals, err := ws.DB.GetWithdrawList(r.Context(), datamodels.OrderInfo{UserID: user.ID})
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, withdrawals)
+}
+
+func (ws wrapperStruct) AdminUserBalance(w http.ResponseWriter, r *http.Request) {
+	user, err := ws.adminTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	balance, err := ws.DB.Balance(r.Context(), datamodels.OrderInfo{UserID: user.ID})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, balance)
+}
+
+// AdminRequeueOrder sends an uncredited order back to the accrual poller.
+func (ws wrapperStruct) AdminRequeueOrder(w http.ResponseWriter, r *http.Request) {
+	number := mux.Vars(r)["number"]
+	if err := ws.DB.RequeueOrder(r.Context(), number); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := ws.audit(r, staffFrom(r).ID, audit.ActionAdminRequeue, number, nil, map[string]string{"status": "NEW"}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (ws wrapperStruct) AdminInvalidateOrder(w http.ResponseWriter, r *http.Request) {
+	number := mux.Vars(r)["number"]
+	if err := ws.DB.InvalidateOrder(r.Context(), number); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := ws.audit(r, staffFrom(r).ID, audit.ActionAdminInvalidate, number, nil, map[string]string{"status": "INVALID"}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdminAdjustBalance posts a manual credit or, with a negative sum, a debit.
+func (ws wrapperStruct) AdminAdjustBalance(w http.ResponseWriter, r *http.Request) {
+	user, err := ws.adminTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.Adjustment
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	v := validation.New()
+	if body.Sum < 0 {
+		v.Sum("sum", -body.Sum)
+	} else {
+		v.Sum("sum", body.Sum)
+	}
+	v.Check(body.Sum >= -maxAdjustment && body.Sum <= maxAdjustment, "sum", "range", "sum must be from -100000 to 100000")
+	v.Check(body.Reason != "" && utf8.RuneCountInString(body.Reason) <= 500, "reason", "required", "reason must be 1 to 500 characters long")
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := ws.DB.Balance(r.Context(), datamodels.OrderInfo{UserID: user.ID})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.DB.AdjustBalance(r.Context(), user.ID, staffFrom(r).ID, body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after := before
+	after.Current += body.Sum
+	err = ws.audit(r, staffFrom(r).ID, audit.ActionAdminAdjust, strconv.Itoa(user.ID), before, struct {
+		datamodels.Balance
+		datamodels.Adjustment
+	}{after, body})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (ws wrapperStruct) AdminBlockUser(w http.ResponseWriter, r *http.Request) {
+	ws.setBlocked(w, r, true)
+}
+
+func (ws wrapperStruct) AdminUnblockUser(w http.ResponseWriter, r *http.Request) {
+	ws.setBlocked(w, r, false)
+}
+
+func (ws wrapperStruct) setBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	user, err := ws.adminTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// also stops staff from blocking themselves
+	if roleRank[user.Role] >= roleRank[staffFrom(r).Role] {
+		writeError(w, r, ErrForbidden)
+		return
+	}
+	if err = ws.DB.SetUserBlocked(r.Context(), user.ID, blocked); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	action := audit.ActionAdminUnblock
+	if blocked {
+		action = audit.ActionAdminBlock
+	}
+	if err = ws.audit(r, staffFrom(r).ID, action, strconv.Itoa(user.ID), map[string]bool{"blocked": user.Blocked}, map[string]bool{"blocked": blocked}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdminRefundWithdrawal reverses all or part of a withdrawal. It is open to admins and to partner
+// accounts, usually through an API key with the refunds scope; partners may only refund
+// withdrawals made at their own checkout.
+func (ws wrapperStruct) AdminRefundWithdrawal(w http.ResponseWriter, r *http.Request) {
+	account, err := ws.currentAccount(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if account.Role != datamodels.RoleAdmin && account.Role != datamodels.RolePartner {
+		writeError(w, r, ErrForbidden)
+		return
+	}
+	var body datamodels.RefundRequest
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	v := validation.New()
+	v.Check(body.Full != (body.Sum != nil), "sum", "required", "give either sum or full: true")
+	if body.Sum != nil {
+		v.Sum("sum", *body.Sum)
+	}
+	v.Check(utf8.RuneCountInString(body.Reason) <= 500, "reason", "length", "reason must be at most 500 characters long")
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	partnerID := 0
+	if account.Role == datamodels.RolePartner {
+		partnerID = account.ID
+	}
+	number := mux.Vars(r)["number"]
+	refund, err := ws.DB.RefundWithdrawal(r.Context(), number, body, account.ID, partnerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, account.ID, audit.ActionAdminRefund, number, nil, refund); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusCreated, refund)
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/apierror"
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/bruteforce"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/utils"
+	"github.com/N0rkton/gophermart/internal/validation"
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiKeyKey         contextKey = 2
+	scopeGrantedKey   contextKey = 3
+	apiKeyScheme                 = "ApiKey "
+	apiKeyTag                    = "gm_"
+	apiKeyPrefixLen              = 8
+	apiKeySecretLen              = 32
+	maxAPIKeyNameLen             = 100
+	maxAPIKeysPerUser            = 20
+)
+
+var apiKeyScopes = []string{datamodels.ScopeOrdersWrite, datamodels.ScopeOrdersRead, datamodels.ScopeBalanceRead, datamodels.ScopeWithdraw, datamodels.ScopeRefund}
+
+var (
+	ErrAPIKeyScope    = apierror.New(http.StatusForbidden, "api_key_scope", "the API key does not allow this operation")
+	ErrTooManyAPIKeys = apierror.New(http.StatusConflict, "too_many_api_keys", "API key limit reached")
+)
+
+// newAPIKey returns a key of the form gm_<prefix>_<secret> and its visible prefix gm_<prefix>.
+func newAPIKey() (string, string) {
+	random := strings.ToLower(utils.GenerateRandomString(25))
+	prefix := apiKeyTag + random[:apiKeyPrefixLen]
+	return prefix + "_" + random[apiKeyPrefixLen:apiKeyPrefixLen+apiKeySecretLen], prefix
+}
+
+// authenticateAPIKey resolves the key of an Authorization: ApiKey header; failures count against the client address.
+func (ws wrapperStruct) authenticateAPIKey(r *http.Request, key string) (datamodels.APIKey, error) {
+	ipKey := bruteforce.IPKey(clientIP(r))
+	if err := ws.guard.Check(r.Context(), ipKey); err != nil {
+		return datamodels.APIKey{}, err
+	}
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(key, apiKeyTag), "_")
+	if !ok || !strings.HasPrefix(key, apiKeyTag) {
+		return datamodels.APIKey{}, storage.ErrAPIKeyInvalid
+	}
+	apiKey, err := ws.APIKeys.AuthenticateAPIKey(r.Context(), apiKeyTag+prefix, utils.GetSHA256Hash(key))
+	if errors.Is(err, storage.ErrAPIKeyInvalid) {
+		if err := ws.guard.Fail(r.Context(), ipKey, ws.limits.ip); err != nil {
+			log.WarnContext(r.Context(), "record api key attempt", "err", err)
+		}
+	}
+	return apiKey, err
+}
+
+func apiKeyFrom(r *http.Request) (datamodels.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyKey).(datamodels.APIKey)
+	return key, ok
+}
+
+// Scope lets API key requests reach h only when the key has scope. Handlers not wrapped
+// in Scope reject API keys, so keys can never manage keys, passwords or the account.
+func (ws wrapperStruct) Scope(scope string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := apiKeyFrom(r); ok {
+			if !slices.Contains(key.Scopes, scope) {
+				writeError(w, r, ErrAPIKeyScope)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), scopeGrantedKey, true))
+		}
+		h(w, r)
+	})
+}
+
+// CreateAPIKey issues a key; the secret is returned only in this response.
+func (ws wrapperStruct) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	account, err := ws.currentAccount(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.NewAPIKey
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Check(body.Name != "" && len(body.Name) <= maxAPIKeyNameLen, "name", "length", "name must be 1 to 100 characters long")
+	v.Check(len(body.Scopes) > 0, "scopes", "required", "at least one scope is required")
+	for _, s := range body.Scopes {
+		v.Check(slices.Contains(apiKeyScopes, s), "scopes", "unknown", "unknown scope "+strconv.Quote(s))
+	}
+	v.Check(!slices.Contains(body.Scopes, datamodels.ScopeRefund) || account.Role == datamodels.RolePartner || account.Role == datamodels.RoleAdmin,
+		"scopes", "forbidden", "only partner and admin accounts can create keys with the refunds scope")
+	v.Check(body.ExpiresAt == nil || body.ExpiresAt.After(time.Now()), "expires_at", "past", "expires_at must be in the future")
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id := account.ID
+	keys, err := ws.APIKeys.GetAPIKeys(r.Context(), id)
+	if err != nil && !errors.Is(err, storage.ErrNoData) {
+		writeError(w, r, err)
+		return
+	}
+	if len(keys) >= maxAPIKeysPerUser {
+		writeError(w, r, ErrTooManyAPIKeys)
+		return
+	}
+	scopes := slices.Clone(body.Scopes)
+	slices.Sort(scopes)
+	secret, prefix := newAPIKey()
+	key, err := ws.APIKeys.CreateAPIKey(r.Context(), datamodels.APIKey{
+		UserID:    id,
+		Name:      body.Name,
+		Prefix:    prefix,
+		Scopes:    slices.Compact(scopes),
+		ExpiresAt: body.ExpiresAt,
+	}, utils.GetSHA256Hash(secret))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionAPIKeyCreate, key.Prefix, nil, key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	key.Key = secret
+	writeJSONStatus(w, r, http.StatusCreated, key)
+}
+
+func (ws wrapperStruct) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	keys, err := ws.APIKeys.GetAPIKeys(r.Context(), id)
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, keys)
+}
+
+func (ws wrapperStruct) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	keyID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, storage.ErrAPIKeyNotFound)
+		return
+	}
+	if err = ws.APIKeys.DeleteAPIKey(r.Context(), id, keyID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionAPIKeyDelete, strconv.Itoa(keyID), nil, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
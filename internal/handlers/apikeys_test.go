@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/N0rkton/gophermart/internal/apierror"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/sessionstorage"
+	"github.com/N0rkton/gophermart/internal/storage"
+)
+
+// usersDB serves GetUser from a map; other storage methods are not used by these tests.
+type usersDB struct {
+	storage.Storage
+	users map[int]datamodels.User
+}
+
+func (db usersDB) GetUser(_ context.Context, id int) (datamodels.User, error) {
+	user, ok := db.users[id]
+	if !ok {
+		return datamodels.User{}, storage.ErrNotFound
+	}
+	return user, nil
+}
+
+func TestScope(t *testing.T) {
+	sessions := sessionstorage.NewAuthUsersStorage()
+	if err := sessions.AddUser("session", 1); err != nil {
+		t.Fatal(err)
+	}
+	ws := wrapperStruct{authUsers: sessions, DB: usersDB{users: map[int]datamodels.User{
+		1: {ID: 1, Login: "alice"},
+		2: {ID: 2, Login: "bob"},
+		3: {ID: 3, Login: "carol", Blocked: true},
+	}}}
+	// whoami answers with the ID of the account the request acts for
+	whoami := func(w http.ResponseWriter, r *http.Request) {
+		account, err := ws.currentAccount(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Write([]byte(strconv.Itoa(account.ID)))
+	}
+	balanceKey := &datamodels.APIKey{UserID: 2, Scopes: []string{datamodels.ScopeBalanceRead}}
+	tests := []struct {
+		name    string
+		session string
+		key     *datamodels.APIKey
+		handler http.Handler
+		status  int
+		// want is the acting user's ID or the problem code
+		want string
+	}{
+		{"session", "session", nil, ws.Scope(datamodels.ScopeBalanceRead, whoami), http.StatusOK, "1"},
+		{"session on an unscoped route", "session", nil, http.HandlerFunc(whoami), http.StatusOK, "1"},
+		{"no credentials", "err", nil, ws.Scope(datamodels.ScopeBalanceRead, whoami), http.StatusUnauthorized, "unauthorized"},
+		{"key with the scope acts for its owner", "session", balanceKey, ws.Scope(datamodels.ScopeBalanceRead, whoami), http.StatusOK, "2"},
+		{"key without the scope", "err", balanceKey, ws.Scope(datamodels.ScopeWithdraw, whoami), http.StatusForbidden, "api_key_scope"},
+		{"key on an unscoped route", "session", balanceKey, http.HandlerFunc(whoami), http.StatusForbidden, "api_key_scope"},
+		{"key of a blocked account", "err", &datamodels.APIKey{UserID: 3, Scopes: []string{datamodels.ScopeBalanceRead}},
+			ws.Scope(datamodels.ScopeBalanceRead, whoami), apierror.From(storage.ErrUserBlocked).Status, apierror.From(storage.ErrUserBlocked).Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+			ctx := context.WithValue(r.Context(), authenticatedUserKey, tt.session)
+			if tt.key != nil {
+				ctx = context.WithValue(ctx, apiKeyKey, *tt.key)
+			}
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r.WithContext(ctx))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			got := w.Body.String()
+			if w.Code != http.StatusOK {
+				var problem struct{ Code string }
+				json.Unmarshal(w.Body.Bytes(), &problem)
+				got = problem.Code
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
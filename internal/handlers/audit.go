@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/logger"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/validation"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+// audit records a sensitive action performed in request r; actorID 0 means an anonymous actor.
+// The entry is written even if the client has gone away, since the action has already taken
+// effect; callers fail the request when it cannot be written.
+func (ws wrapperStruct) audit(r *http.Request, actorID int, action string, target string, before any, after any) error {
+	e := audit.Entry{
+		Action:    action,
+		Target:    target,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: logger.RequestIDFrom(r.Context()),
+		Before:    audit.Marshal(before),
+		After:     audit.Marshal(after),
+	}
+	if actorID != 0 {
+		e.ActorID = &actorID
+	}
+	if err := ws.DB.AppendAudit(context.WithoutCancel(r.Context()), e); err != nil {
+		log.ErrorContext(r.Context(), "append audit log", "action", action, "err", err)
+		return err
+	}
+	return nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AdminAudit queries the audit log with the actor, action, target, from, to, before_id and limit parameters.
+func (ws wrapperStruct) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := audit.Filter{Action: q.Get("action"), Target: q.Get("target"), Limit: defaultAuditLimit}
+	v := validation.New()
+	var err error
+	if s := q.Get("actor"); s != "" {
+		f.ActorID, err = strconv.Atoi(s)
+		v.Check(err == nil, "actor", "integer", "actor must be a user ID")
+	}
+	if s := q.Get("before_id"); s != "" {
+		f.BeforeID, err = strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil, "before_id", "integer", "before_id must be an entry ID")
+	}
+	if s := q.Get("limit"); s != "" {
+		f.Limit, err = strconv.Atoi(s)
+		v.Check(err == nil && f.Limit > 0 && f.Limit <= maxAuditLimit, "limit", "range", "limit must be from 1 to 500")
+	}
+	if s := q.Get("from"); s != "" {
+		f.From, err = time.Parse(time.RFC3339, s)
+		v.Check(err == nil, "from", "time", "from must be an RFC 3339 time")
+	}
+	if s := q.Get("to"); s != "" {
+		f.To, err = time.Parse(time.RFC3339, s)
+		v.Check(err == nil, "to", "time", "to must be an RFC 3339 time")
+	}
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	entries, err := ws.DB.QueryAudit(r.Context(), f)
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, entries)
+}
+
+type auditVerification struct {
+	Valid    bool  `json:"valid"`
+	Checked  int   `json:"checked"`
+	BrokenAt int64 `json:"broken_at,omitempty"`
+}
+
+// AdminAuditVerify recomputes the hash chain of the audit log.
+func (ws wrapperStruct) AdminAuditVerify(w http.ResponseWriter, r *http.Request) {
+	checked, broken, err := ws.DB.VerifyAudit(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if broken != 0 {
+		log.ErrorContext(r.Context(), "audit log chain broken", "entry", broken)
+	}
+	writeJSON(w, r, auditVerification{Valid: broken == 0, Checked: checked, BrokenAt: broken})
+}
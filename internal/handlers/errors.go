@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/N0rkton/gophermart/internal/apierror"
+	"github.com/N0rkton/gophermart/internal/bruteforce"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrLoginTaken   = apierror.New(http.StatusConflict, "login_taken", "login already exists")
+	ErrForbidden    = apierror.New(http.StatusForbidden, "forbidden", "insufficient permissions")
+	ErrUserNotFound = apierror.New(http.StatusNotFound, "user_not_found", "user not found")
+
+	ErrInvalidCredentials = apierror.New(http.StatusUnauthorized, "invalid_credentials", "invalid login or password")
+	ErrTooManyAttempts    = apierror.New(http.StatusTooManyRequests, "too_many_attempts", "too many attempts, try again later")
+
+	ErrOTPRequired          = apierror.New(http.StatusUnauthorized, "otp_required", "a one-time code is required in the X-OTP-Code header")
+	ErrMFATokenInvalid      = apierror.New(http.StatusUnauthorized, "mfa_token_invalid", "the login token is invalid or expired")
+	ErrTwoFactorNotEnabled  = apierror.New(http.StatusConflict, "two_factor_not_enabled", "two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled = apierror.New(http.StatusConflict, "two_factor_not_enrolled", "start enrolment before enabling two-factor authentication")
+
+	ErrWrongCurrentPassword = apierror.New(http.StatusForbidden, "wrong_current_password", "current password is incorrect")
+)
+
+func init() {
+	apierror.Register(storage.ErrNotFound, apierror.New(http.StatusBadRequest, "user_not_found", "user not found"))
+	apierror.Register(storage.ErrWrongPassword, apierror.New(http.StatusUnauthorized, "wrong_password", "invalid password"))
+	apierror.Register(storage.ErrInvalidOrder, apierror.New(http.StatusUnprocessableEntity, "invalid_order_number", "invalid order number"))
+	apierror.Register(storage.ErrAlreadyOrdered, apierror.New(http.StatusConflict, "order_already_uploaded", "the order number has already been uploaded by this user"))
+	apierror.Register(storage.ErrNoData, apierror.New(http.StatusNotFound, "no_data", "no data found"))
+	apierror.Register(storage.ErrAnotherUserOrder, apierror.New(http.StatusConflict, "order_owned_by_another_user", "the order number has already been uploaded by another user"))
+	apierror.Register(storage.ErrNotEnoughMoney, apierror.New(http.StatusPaymentRequired, "insufficient_funds", "not enough points"))
+	apierror.Register(storage.ErrUserBlocked, apierror.New(http.StatusForbidden, "account_blocked", "the account is blocked"))
+	apierror.Register(storage.ErrOrderNotFound, apierror.New(http.StatusNotFound, "order_not_found", "order not found"))
+	apierror.Register(storage.ErrOrderState, apierror.New(http.StatusConflict, "order_state_conflict", "order status does not allow this operation"))
+	apierror.Register(storage.ErrWebhookNotFound, apierror.New(http.StatusNotFound, "webhook_not_found", "webhook not found"))
+	apierror.Register(storage.ErrTwoFactorEnabled, apierror.New(http.StatusConflict, "two_factor_enabled", "two-factor authentication is already enabled"))
+	apierror.Register(storage.ErrInvalidTOTP, apierror.New(http.StatusUnauthorized, "invalid_otp", "invalid or already used one-time code"))
+	apierror.Register(storage.ErrResetTokenInvalid, apierror.New(http.StatusBadRequest, "reset_token_invalid", "the reset token is invalid or expired"))
+	apierror.Register(storage.ErrEmailTaken, apierror.New(http.StatusConflict, "email_taken", "email is used by another account"))
+	apierror.Register(storage.ErrVerificationNotFound, apierror.New(http.StatusBadRequest, "verification_token_invalid", "the verification token is invalid or expired"))
+	apierror.Register(storage.ErrAPIKeyNotFound, apierror.New(http.StatusNotFound, "api_key_not_found", "API key not found"))
+	apierror.Register(storage.ErrAPIKeyInvalid, apierror.New(http.StatusUnauthorized, "invalid_api_key", "invalid or expired API key"))
+	apierror.Register(storage.ErrTransferRejected, apierror.New(http.StatusUnprocessableEntity, "transfer_rejected", "the recipient cannot receive points"))
+	apierror.Register(storage.ErrTransferToSelf, apierror.New(http.StatusBadRequest, "transfer_to_self", "cannot transfer points to yourself"))
+	apierror.Register(storage.ErrTransferLimit, apierror.New(http.StatusForbidden, "transfer_limit_exceeded", "daily transfer limit exceeded"))
+	apierror.Register(storage.ErrHoldNotFound, apierror.New(http.StatusNotFound, "hold_not_found", "hold not found"))
+	apierror.Register(storage.ErrHoldState, apierror.New(http.StatusConflict, "hold_not_active", "the hold has already been captured, cancelled or expired"))
+	apierror.Register(storage.ErrPartnerNotFound, apierror.New(http.StatusUnprocessableEntity, "unknown_partner", "no partner account with this login"))
+	apierror.Register(storage.ErrWithdrawalNotFound, apierror.New(http.StatusNotFound, "withdrawal_not_found", "withdrawal not found"))
+	apierror.Register(storage.ErrRefundExceeds, apierror.New(http.StatusConflict, "refund_exceeds_withdrawal", "the refund exceeds what is left of the withdrawal"))
+	apierror.Register(storage.ErrInternal, apierror.ErrInternal)
+}
+
+// writeError renders err as problem+json and logs it when it is not a client error.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var locked *bruteforce.LockedError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", locked.RetryAfterSeconds())
+		err = ErrTooManyAttempts
+	}
+	apiErr := apierror.Write(w, r, err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.ErrorContext(r.Context(), "request failed", "err", err)
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
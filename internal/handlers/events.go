@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	eventsBatch       = 100
+	eventsHeartbeat   = 15 * time.Second
+	eventsRetryMillis = 3000
+)
+
+// OrderEvents streams the user's order and withdrawal events as Server-Sent Events.
+// A Last-Event-ID header resumes the stream after the given event; without it only new events are sent.
+func (ws wrapperStruct) OrderEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rc := http.NewResponseController(w)
+	wake, cancel := ws.events.Subscribe(id)
+	defer cancel()
+
+	lastID, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		lastID, err = ws.DB.LastEventID(r.Context(), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetryMillis)
+	if err = rc.Flush(); err != nil {
+		log.WarnContext(r.Context(), "order events stream", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		for {
+			events, err := ws.DB.GetEventsSince(r.Context(), id, lastID, eventsBatch)
+			if err != nil {
+				log.WarnContext(r.Context(), "read order events", "err", err)
+				return
+			}
+			for _, e := range events {
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Payload)
+				lastID = e.ID
+			}
+			if len(events) > 0 {
+				rc.Flush()
+			}
+			if len(events) < eventsBatch {
+				break
+			}
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			rc.Flush()
+		}
+	}
+}
@@ -1,296 +1,499 @@
 package handlers
 
 import (
-	"compress/gzip"
 	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/N0rkton/gophermart/internal/apierror"
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/bruteforce"
 	conf "github.com/N0rkton/gophermart/internal/config"
 	"github.com/N0rkton/gophermart/internal/cookies"
 	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/events"
+	"github.com/N0rkton/gophermart/internal/logger"
+	"github.com/N0rkton/gophermart/internal/notify"
 	"github.com/N0rkton/gophermart/internal/sessionstorage"
 	"github.com/N0rkton/gophermart/internal/storage"
 	"github.com/N0rkton/gophermart/internal/utils"
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx/v5/pgconn"
-	"io"
-	"log"
+	"github.com/N0rkton/gophermart/internal/validation"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
+var log = logger.For("handlers")
+
 type wrapperStruct struct {
 	DB        storage.Storage
+	Users     storage.UserRepository
+	APIKeys   storage.APIKeyRepository
 	secret    []byte
 	authUsers sessionstorage.SessionStorage
+	events    *events.Broker
+	guard     *bruteforce.Guard
+	limits    authLimits
+	pending   sessionstorage.PendingStorage
+	notifier  notify.Notifier
+	// otpThreshold is the withdrawal sum above which 2FA users must send a fresh code.
+	otpThreshold float64
+	// transferLimit caps the points a user may transfer per 24 hours; 0 means no limit.
+	transferLimit float64
+	holdTTL       time.Duration
 }
 
-type gzipWriter struct {
-	http.ResponseWriter
-	Writer io.Writer
+// authLimits are the brute-force policies for login and registration keys.
+type authLimits struct {
+	login    bruteforce.Policy
+	ip       bruteforce.Policy
+	register bruteforce.Policy
+	reset    bruteforce.Policy
 }
 
-func (w gzipWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
-func gzipDecode(r *http.Request) io.ReadCloser {
-	if r.Header.Get(`Content-Encoding`) == `gzip` {
-		gz, _ := gzip.NewReader(r.Body)
-		defer gz.Close()
-		return gz
+func newAuthLimits(c conf.BruteForceCfg) authLimits {
+	return authLimits{
+		login: bruteforce.Policy{MaxFailures: *c.LoginMaxFailures, Window: *c.Lockout, BaseDelay: time.Second,
+			MaxDelay: 30 * time.Second, Lockout: *c.Lockout},
+		ip:       bruteforce.Policy{MaxFailures: *c.IPMaxFailures, Window: *c.Lockout, Lockout: *c.Lockout},
+		register: bruteforce.Policy{MaxFailures: *c.RegistrationsPerIP, Window: time.Hour, Lockout: time.Hour},
+		reset:    bruteforce.Policy{MaxFailures: resetsPerLogin, Window: time.Hour, Lockout: time.Hour},
 	}
-	return r.Body
 }
 
 type contextKey int
 
 const authenticatedUserKey contextKey = 0
 
-func (ws wrapperStruct) GzipHandle(next http.Handler) http.Handler {
+// Authenticate resolves the session cookie and stores the session key in the request context;
+// an Authorization: ApiKey header is checked and its key stored as well.
+func (ws wrapperStruct) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, err := cookies.ReadEncrypted(r, "UserID", ws.secret)
 		if err != nil {
 			user = "err"
 		}
 		ctxWithUser := context.WithValue(r.Context(), authenticatedUserKey, user)
-		rWithUser := r.WithContext(ctxWithUser)
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, rWithUser)
-			return
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, apiKeyScheme) {
+			key, err := ws.authenticateAPIKey(r, strings.TrimPrefix(header, apiKeyScheme))
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			ctxWithUser = context.WithValue(ctxWithUser, apiKeyKey, key)
 		}
-		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-		if err != nil {
-			io.WriteString(w, err.Error())
-			return
-		}
-		defer gz.Close()
-		w.Header().Set("Content-Encoding", "gzip")
-		rWithUser.Body = gzipDecode(r)
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, rWithUser)
+		next.ServeHTTP(w, r.WithContext(ctxWithUser))
 	})
 }
 
 func Init() wrapperStruct {
 	config := conf.NewConfig()
 	var err error
+	if err = logger.Init(*config.LogLevel); err != nil {
+		log.Error("invalid log level", "err", err)
+		os.Exit(1)
+	}
 
-	db, err := storage.NewDBStorage(*config.DBAddress)
+	var db storage.Storage
+	var users storage.UserRepository
+	var apiKeys storage.APIKeyRepository
+	dbs, err := storage.NewDBStorage(*config.DBAddress)
 	if err != nil {
-		log.Println(err)
+		log.Error("storage init failed", "err", err)
+	} else {
+		dbs.SetExpiryPolicy(storage.ExpiryPolicy{LifetimeMonths: *config.PointsExpiry.LifetimeMonths, Notice: *config.PointsExpiry.Notice})
+		db = storage.NewInstrumented(dbs)
+		users = storage.NewInstrumentedUsers(storage.NewUserRepository(dbs))
+		apiKeys = storage.NewInstrumentedAPIKeys(storage.NewAPIKeyRepository(dbs))
 	}
 	secret, err := hex.DecodeString("13d6b4dff8f84a10851021ec8608f814570d562c92fe6b5ec4c9f595bcb3234b")
 	if err != nil {
-		log.Fatal(err)
+		log.Error("invalid cookie secret", "err", err)
+		os.Exit(1)
+	}
+	if db != nil {
+		for _, login := range config.AdminLogins() {
+			if err = db.SetUserRole(context.Background(), login, datamodels.RoleAdmin); err != nil {
+				log.Warn("grant admin role", "login", login, "err", err)
+			}
+		}
+	}
+	notifier, err := notify.Parse(*config.Notifier)
+	if err != nil {
+		log.Error("notifier", "err", err)
+		os.Exit(1)
 	}
 	authUsers := sessionstorage.NewAuthUsersStorage()
-	return wrapperStruct{DB: db, secret: secret, authUsers: authUsers}
+	broker := events.NewBroker()
+	go broker.Listen(context.Background(), *config.DBAddress, storage.EventsChannel)
+	return wrapperStruct{DB: db, Users: users, APIKeys: apiKeys, secret: secret, authUsers: authUsers, events: broker,
+		guard: bruteforce.NewGuard(db), limits: newAuthLimits(config.BruteForce),
+		pending: sessionstorage.NewPendingStorage(), notifier: notifier, otpThreshold: *config.TOTPThreshold,
+		transferLimit: *config.TransferLimit, holdTTL: *config.HoldTTL}
 }
 
 func (ws wrapperStruct) Register(w http.ResponseWriter, r *http.Request) {
 	var body datamodels.Reg
-	err := json.NewDecoder(r.Body).Decode(&body)
+	err := decodeJSON(w, r, &body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Login("login", body.Login)
+	v.Password("password", body.Password)
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	registerKey := bruteforce.RegisterKey(clientIP(r))
+	if err = ws.guard.Attempt(r.Context(), registerKey, ws.limits.register); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	password := utils.GetMD5Hash(body.Password)
-	err = ws.DB.Register(body.Login, password)
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-		http.Error(w, "login already exists", http.StatusConflict)
+	err = ws.DB.Register(r.Context(), body.Login, password)
+	if isUniqueViolation(err) {
+		writeError(w, r, ErrLoginTaken)
 		return
 	}
 	if err != nil {
-		http.Error(w, "-", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	id, err := ws.DB.Login(body.Login, password)
+	id, err := ws.DB.Login(r.Context(), body.Login, password)
 	if err != nil {
-		http.Error(w, "server err", http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
-	user := utils.GenerateRandomString(3)
-	cookie := http.Cookie{
-		Name:     "UserID",
-		Value:    user,
-		Path:     "/api/user",
-		HttpOnly: true,
-		Secure:   false,
+	if err = ws.audit(r, id, audit.ActionRegister, body.Login, nil, nil); err != nil {
+		writeError(w, r, err)
+		return
 	}
-	err = cookies.WriteEncrypted(w, cookie, ws.secret)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "server error", http.StatusInternalServerError)
+	if err = ws.startSession(w, id); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	ws.authUsers.AddUser(user, id)
-
 	w.WriteHeader(http.StatusOK)
 }
 
 func (ws wrapperStruct) Login(w http.ResponseWriter, r *http.Request) {
 	var body datamodels.Reg
-	err := json.NewDecoder(r.Body).Decode(&body)
+	err := decodeJSON(w, r, &body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.KnownLogin("login", body.Login)
+	v.Check(body.Password != "", "password", "required", "password is required")
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	loginKey, ipKey := bruteforce.LoginKey(body.Login), bruteforce.IPKey(clientIP(r))
+	if err = ws.loginAttempt(r, loginKey, ipKey); err != nil {
+		ws.audit(r, 0, audit.ActionLoginFailed, body.Login, nil, map[string]string{"reason": "too_many_attempts"})
+		writeError(w, r, err)
 		return
 	}
 	password := utils.GetMD5Hash(body.Password)
-	fmt.Println(password)
-	id, ok := ws.DB.Login(body.Login, password)
-	if ok != nil {
-		status := mapErr(ok)
-		http.Error(w, ok.Error(), status)
+	id, err := ws.DB.Login(r.Context(), body.Login, password)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrWrongPassword) {
+		ws.audit(r, 0, audit.ActionLoginFailed, body.Login, nil, map[string]string{"reason": apierror.From(err).Code})
+		writeError(w, r, ErrInvalidCredentials)
+		return
+	}
+	if err != nil {
+		ws.audit(r, 0, audit.ActionLoginFailed, body.Login, nil, map[string]string{"reason": apierror.From(err).Code})
+		ws.forgiveLogin(r, loginKey, ipKey)
+		writeError(w, r, err)
 		return
 	}
+	if err = ws.guard.Reset(r.Context(), loginKey); err != nil {
+		log.WarnContext(r.Context(), "reset login attempts", "err", err)
+	}
+	if err = ws.guard.Forgive(r.Context(), ipKey, ws.limits.ip); err != nil {
+		log.WarnContext(r.Context(), "forgive login attempt", "err", err)
+	}
+	tf, err := ws.DB.GetTwoFactor(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if tf.Enabled {
+		ws.startPendingLogin(w, r, id)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionLogin, body.Login, nil, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.startSession(w, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// loginAttempt counts a login against both the login and the client address before the password
+// is checked, so parallel guesses cannot all pass a check made before any of them failed.
+func (ws wrapperStruct) loginAttempt(r *http.Request, loginKey, ipKey string) error {
+	if err := ws.guard.Attempt(r.Context(), loginKey, ws.limits.login); err != nil {
+		return err
+	}
+	if err := ws.guard.Attempt(r.Context(), ipKey, ws.limits.ip); err != nil {
+		if ferr := ws.guard.Forgive(r.Context(), loginKey, ws.limits.login); ferr != nil {
+			log.WarnContext(r.Context(), "forgive login attempt", "err", ferr)
+		}
+		return err
+	}
+	return nil
+}
+
+// forgiveLogin takes back a login attempt that failed for reasons other than the credentials.
+func (ws wrapperStruct) forgiveLogin(r *http.Request, loginKey, ipKey string) {
+	if err := ws.guard.Forgive(r.Context(), loginKey, ws.limits.login); err != nil {
+		log.WarnContext(r.Context(), "forgive login attempt", "err", err)
+	}
+	if err := ws.guard.Forgive(r.Context(), ipKey, ws.limits.ip); err != nil {
+		log.WarnContext(r.Context(), "forgive login attempt", "err", err)
+	}
+}
+
+// startSession issues the encrypted UserID cookie and remembers the session.
+func (ws wrapperStruct) startSession(w http.ResponseWriter, id int) error {
 	user := utils.GenerateRandomString(3)
 	cookie := http.Cookie{
 		Name:     "UserID",
 		Value:    user,
-		Path:     "/api/user",
+		Path:     "/api",
 		HttpOnly: true,
 		Secure:   false,
 	}
-	err = cookies.WriteEncrypted(w, cookie, ws.secret)
+	if err := cookies.WriteEncrypted(w, cookie, ws.secret); err != nil {
+		return err
+	}
+	return ws.authUsers.AddUser(user, id)
+}
+
+// currentUser returns the ID of the user owning the request session.
+func (ws wrapperStruct) currentUser(r *http.Request) (int, error) {
+	account, err := ws.currentAccount(r)
+	return account.ID, err
+}
+
+// currentAccount loads the user owning the request session or API key and rejects blocked accounts.
+func (ws wrapperStruct) currentAccount(r *http.Request) (datamodels.User, error) {
+	id, err := ws.authUsers.GetUser(r.Context().Value(authenticatedUserKey).(string))
+	if key, ok := apiKeyFrom(r); ok {
+		if granted, _ := r.Context().Value(scopeGrantedKey).(bool); !granted {
+			return datamodels.User{}, ErrAPIKeyScope
+		}
+		id, err = key.UserID, nil
+	}
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "server error", http.StatusInternalServerError)
-		return
+		return datamodels.User{}, apierror.ErrUnauthorized
 	}
-	ws.authUsers.AddUser(user, id)
-	w.WriteHeader(http.StatusOK)
+	account, err := ws.DB.GetUser(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return datamodels.User{}, apierror.ErrUnauthorized
+	}
+	if err != nil {
+		return datamodels.User{}, err
+	}
+	if account.Blocked {
+		return datamodels.User{}, storage.ErrUserBlocked
+	}
+	return account, nil
 }
 
 func (ws wrapperStruct) OrdersPost(w http.ResponseWriter, r *http.Request) {
-	order, err := io.ReadAll(r.Body)
+	id, err := ws.currentUser(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	id, ok2 := ws.authUsers.GetUser(r.Context().Value(authenticatedUserKey).(string))
-	if ok2 != nil {
-		http.Error(w, "Unauthorized user", http.StatusUnauthorized)
+	order, err := readText(w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	orderNum, err := strconv.Atoi(string(order))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err = validation.New().OrderNumber("order", order); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	ok := ws.DB.OrdersPost(datamodels.OrderInfo{UserID: id, OrderID: orderNum})
-	if ok != nil {
-		status := mapErr(ok)
-		http.Error(w, ok.Error(), status)
+	err = ws.DB.OrdersPost(r.Context(), datamodels.OrderInfo{UserID: id, OrderID: order})
+	if errors.Is(err, storage.ErrAlreadyOrdered) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
-func (ws wrapperStruct) OrdersGet(w http.ResponseWriter, r *http.Request) {
-	id, ok2 := ws.authUsers.GetUser(r.Context().Value(authenticatedUserKey).(string))
-	if ok2 != nil {
-		http.Error(w, "Unauthorized user", http.StatusUnauthorized)
+
+const maxBatchSize = 1000
+
+var ErrBatchSize = apierror.New(http.StatusBadRequest, "invalid_batch_size", "batch must contain from 1 to 1000 order numbers")
+
+// OrdersPostBatch uploads a JSON array or newline-delimited list of order numbers and reports the result per item.
+func (ws wrapperStruct) OrdersPostBatch(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	orderList, ok := ws.DB.GetOrderList(datamodels.OrderInfo{UserID: id})
-	if ok != nil {
-		status := mapErr(ok)
-		http.Error(w, ok.Error(), status)
+	var orders []string
+	if requireContentType(r, "text/plain") == nil {
+		var body string
+		body, err = readText(w, r)
+		orders = strings.Fields(body)
+	} else {
+		err = decodeJSON(w, r, &orders)
+	}
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(orderList); err != nil {
-		log.Println("jsonIndexPage: encoding response:", err)
-		http.Error(w, "unable to encode response", http.StatusInternalServerError)
+	if len(orders) == 0 || len(orders) > maxBatchSize {
+		writeError(w, r, ErrBatchSize)
 		return
 	}
+	resp := make([]datamodels.BatchOrderResult, len(orders))
+	var valid []string
+	var positions []int
+	for i, order := range orders {
+		if validation.New().OrderNumber("order", order) != nil {
+			resp[i] = datamodels.BatchOrderResult{Order: order, Status: datamodels.BatchInvalid}
+			continue
+		}
+		valid = append(valid, order)
+		positions = append(positions, i)
+	}
+	if len(valid) > 0 {
+		results, err := ws.DB.OrdersPostBatch(r.Context(), id, valid)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		for i, res := range results {
+			resp[positions[i]] = res
+		}
+	}
+	writeJSON(w, r, resp)
 }
-func (ws wrapperStruct) Balance(w http.ResponseWriter, r *http.Request) {
-	id, ok2 := ws.authUsers.GetUser(r.Context().Value(authenticatedUserKey).(string))
-	if ok2 != nil {
-		http.Error(w, "Unauthorized user", http.StatusUnauthorized)
+
+func (ws wrapperStruct) OrdersGet(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	balance, ok := ws.DB.Balance(datamodels.OrderInfo{UserID: id})
-	if ok != nil {
-		status := mapErr(ok)
-		http.Error(w, ok.Error(), status)
+	orderList, err := ws.DB.GetOrderList(r.Context(), datamodels.OrderInfo{UserID: id})
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(balance); err != nil {
-		log.Println("jsonIndexPage: encoding response:", err)
-		http.Error(w, "unable to encode response", http.StatusInternalServerError)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	writeJSON(w, r, orderList)
+}
+func (ws wrapperStruct) Balance(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	balance, err := ws.DB.Balance(r.Context(), datamodels.OrderInfo{UserID: id})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, balance)
 }
 func (ws wrapperStruct) Withdraw(w http.ResponseWriter, r *http.Request) {
-
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 	var body datamodels.Withdraw
-	err := json.NewDecoder(r.Body).Decode(&body)
+	err = decodeJSON(w, r, &body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	id, ok2 := ws.authUsers.GetUser(r.Context().Value(authenticatedUserKey).(string))
-	if ok2 != nil {
-		http.Error(w, "Unauthorized user", http.StatusUnauthorized)
+	v := validation.New()
+	v.Sum("sum", body.Sum)
+	if err = v.OrderNumber("order", body.Order); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	orderNum, _ := strconv.Atoi(body.Order)
-	ok := ws.DB.Withdraw(datamodels.OrderInfo{UserID: id, OrderID: orderNum, Sum: body.Sum})
-	if ok != nil {
-		status := mapErr(ok)
-		http.Error(w, ok.Error(), status)
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.Sum > ws.otpThreshold {
+		if err = ws.requireFreshOTP(r, id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	err = ws.DB.Withdraw(r.Context(), datamodels.OrderInfo{UserID: id, OrderID: body.Order, Sum: body.Sum, Partner: body.Partner})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionWithdraw, body.Order, nil, body); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 func (ws wrapperStruct) Withdrawals(w http.ResponseWriter, r *http.Request) {
-	id, ok2 := ws.authUsers.GetUser(r.Context().Value(authenticatedUserKey).(string))
-	if ok2 != nil {
-		http.Error(w, "Unauthorized user", http.StatusUnauthorized)
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	withdrawals, ok := ws.DB.GetWithdrawList(datamodels.OrderInfo{UserID: id})
-	if ok != nil {
-		status := mapErr(ok)
-		http.Error(w, ok.Error(), status)
+	withdrawals, err := ws.DB.GetWithdrawList(r.Context(), datamodels.OrderInfo{UserID: id})
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(withdrawals); err != nil {
-		log.Println("jsonIndexPage: encoding response:", err)
-		http.Error(w, "unable to encode response", http.StatusInternalServerError)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	writeJSON(w, r, withdrawals)
 }
 
-func mapErr(err error) int {
-	if errors.Is(err, storage.ErrNotFound) {
-		return http.StatusBadRequest
-	}
-	if errors.Is(err, storage.ErrInvalidOrder) {
-		return http.StatusUnprocessableEntity
-	}
-	if errors.Is(err, storage.ErrWrongPassword) {
-		return http.StatusUnauthorized
-	}
-	if errors.Is(err, storage.ErrAlreadyOrdered) {
-		return http.StatusOK
-	}
-	if errors.Is(err, storage.ErrAnotherUserOrder) {
-		return http.StatusConflict
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.ErrorContext(r.Context(), "encoding response", "err", err)
 	}
-	if errors.Is(err, storage.ErrNoData) {
-		return http.StatusNoContent
+}
+
+// RateLimitClient identifies the caller for rate limiting: the session user when logged in, the address otherwise.
+func (ws wrapperStruct) RateLimitClient(r *http.Request) string {
+	if key, ok := apiKeyFrom(r); ok {
+		return "user:" + strconv.Itoa(key.UserID)
 	}
-	if errors.Is(err, storage.ErrNotEnoughMoney) {
-		return http.StatusPaymentRequired
+	if id, err := ws.authUsers.GetUser(r.Context().Value(authenticatedUserKey).(string)); err == nil {
+		return "user:" + strconv.Itoa(id)
 	}
-	return http.StatusInternalServerError
+	return "ip:" + clientIP(r)
 }
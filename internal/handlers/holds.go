@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/validation"
+	"github.com/gorilla/mux"
+)
+
+// CreateHold reserves points for a withdrawal; like Withdraw, large sums need a fresh one-time code.
+func (ws wrapperStruct) CreateHold(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.Withdraw
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Sum("sum", body.Sum)
+	if err = v.OrderNumber("order", body.Order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.Sum > ws.otpThreshold {
+		if err = ws.requireFreshOTP(r, id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	hold, err := ws.DB.CreateHold(r.Context(), datamodels.OrderInfo{UserID: id, OrderID: body.Order, Sum: body.Sum, Partner: body.Partner}, ws.holdTTL)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionHoldCreate, body.Order, nil, hold); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusCreated, hold)
+}
+
+func (ws wrapperStruct) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	ws.resolveHold(w, r, ws.DB.CaptureHold, audit.ActionHoldCapture)
+}
+
+func (ws wrapperStruct) CancelHold(w http.ResponseWriter, r *http.Request) {
+	ws.resolveHold(w, r, ws.DB.CancelHold, audit.ActionHoldCancel)
+}
+
+func (ws wrapperStruct) resolveHold(w http.ResponseWriter, r *http.Request, resolve func(ctx context.Context, userID int, id int) (datamodels.Hold, error), action string) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	holdID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, storage.ErrHoldNotFound)
+		return
+	}
+	hold, err := resolve(r.Context(), id, holdID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, action, hold.Order, nil, hold); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, hold)
+}
+
+func (ws wrapperStruct) Holds(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	holds, err := ws.DB.GetHolds(r.Context(), id)
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, holds)
+}
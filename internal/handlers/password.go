@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/bruteforce"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/notify"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/utils"
+	"github.com/N0rkton/gophermart/internal/validation"
+)
+
+const (
+	passwordResetTTL = 30 * time.Minute
+	// resetsPerLogin is how many reset mails one login may be sent per hour.
+	resetsPerLogin = 3
+)
+
+// ChangePassword sets a new password after checking the current one, ends the user's other sessions
+// and revokes their API keys.
+func (ws wrapperStruct) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	account, err := ws.currentAccount(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.PasswordChange
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Check(body.CurrentPassword != "", "current_password", "required", "current password is required")
+	v.Password("new_password", body.NewPassword)
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	loginKey := bruteforce.LoginKey(account.Login)
+	if err = ws.guard.Attempt(r.Context(), loginKey, ws.limits.login); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	_, err = ws.DB.Login(r.Context(), account.Login, utils.GetMD5Hash(body.CurrentPassword))
+	if errors.Is(err, storage.ErrWrongPassword) {
+		writeError(w, r, ErrWrongCurrentPassword)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.guard.Reset(r.Context(), loginKey); err != nil {
+		log.WarnContext(r.Context(), "reset login attempts", "err", err)
+	}
+	if err = ws.DB.SetPassword(r.Context(), account.ID, utils.GetMD5Hash(body.NewPassword)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// the sessions are revoked even if the audit entry fails
+	err = errors.Join(
+		ws.audit(r, account.ID, audit.ActionPasswordChange, account.Login, nil, nil),
+		ws.revokeSessions(r, account.ID, r.Context().Value(authenticatedUserKey).(string)),
+	)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RequestPasswordReset mails a single-use reset token to the verified email of the account. It answers
+// 202 whether or not the login exists and has such an email, and sends the message in the background
+// so the response does not reveal it either.
+func (ws wrapperStruct) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var body datamodels.PasswordResetRequest
+	err := decodeJSON(w, r, &body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.KnownLogin("login", body.Login)
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The route limit bounds requests per client; this bounds the mails one login can receive.
+	if err = ws.guard.Attempt(r.Context(), bruteforce.ResetKey(body.Login), ws.limits.reset); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		if err := ws.sendPasswordReset(ctx, body.Login); err != nil {
+			log.WarnContext(ctx, "password reset", "err", err)
+		}
+	}()
+	if err = ws.audit(r, 0, audit.ActionPasswordResetReq, body.Login, nil, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (ws wrapperStruct) sendPasswordReset(ctx context.Context, login string) error {
+	account, err := ws.DB.GetUserByLogin(ctx, login)
+	if errors.Is(err, storage.ErrNotFound) || account.Blocked {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// only a verified address is known to belong to the user, whatever the login looks like
+	profile, err := ws.Users.GetProfile(ctx, account.ID)
+	if err != nil || !profile.EmailVerified {
+		return err
+	}
+	token := utils.GenerateRandomString(20)
+	if err = ws.DB.CreatePasswordReset(ctx, account.ID, utils.GetSHA256Hash(token), time.Now().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+	return ws.notifier.Notify(ctx, notify.PasswordReset(profile.Email, token, passwordResetTTL))
+}
+
+// ResetPassword sets a new password with a reset token, ends all sessions of the user and revokes their API keys.
+func (ws wrapperStruct) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var body datamodels.PasswordReset
+	err := decodeJSON(w, r, &body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Check(body.Token != "", "token", "required", "token is required")
+	v.Password("new_password", body.NewPassword)
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := ws.DB.ResetPassword(r.Context(), utils.GetSHA256Hash(body.Token), utils.GetMD5Hash(body.NewPassword))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// the sessions are revoked even if the audit entry fails
+	err = errors.Join(
+		ws.audit(r, id, audit.ActionPasswordReset, "", nil, nil),
+		ws.revokeSessions(r, id, ""),
+	)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeSessions ends the sessions of id except keep and audits it.
+func (ws wrapperStruct) revokeSessions(r *http.Request, id int, keep string) error {
+	n := ws.authUsers.RevokeUser(id, keep)
+	return ws.audit(r, id, audit.ActionSessionRevoke, "", nil, map[string]int{"sessions": n})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/notify"
+	"github.com/N0rkton/gophermart/internal/utils"
+	"github.com/N0rkton/gophermart/internal/validation"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+func (ws wrapperStruct) GetProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	profile, err := ws.Users.GetProfile(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, profile)
+}
+
+// UpdateProfile applies a partial update; a new email address is mailed a verification token.
+func (ws wrapperStruct) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var patch datamodels.ProfilePatch
+	if err = decodeJSON(w, r, &patch); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	if patch.DisplayName != nil {
+		v.DisplayName("display_name", *patch.DisplayName)
+	}
+	if patch.Email != nil && *patch.Email != "" {
+		v.Email("email", *patch.Email)
+	}
+	if patch.Phone != nil && *patch.Phone != "" {
+		v.Phone("phone", *patch.Phone)
+	}
+	if patch.Locale != nil && *patch.Locale != "" {
+		v.Locale("locale", *patch.Locale)
+	}
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := ws.Users.GetProfile(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	profile, err := ws.Users.UpdateProfile(r.Context(), id, patch)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if profile.Email != "" && !profile.EmailVerified && profile.Email != before.Email {
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			if err := ws.sendEmailVerification(ctx, id, profile.Email); err != nil {
+				log.WarnContext(ctx, "email verification", "err", err)
+			}
+		}()
+	}
+	writeJSON(w, r, profile)
+}
+
+func (ws wrapperStruct) sendEmailVerification(ctx context.Context, id int, email string) error {
+	token := utils.GenerateRandomString(20)
+	if err := ws.Users.CreateEmailVerification(ctx, id, email, utils.GetSHA256Hash(token), time.Now().Add(emailVerificationTTL)); err != nil {
+		return err
+	}
+	return ws.notifier.Notify(ctx, notify.Message{
+		To:      email,
+		Subject: "Confirm your Gophermart email",
+		Body:    "Use this token to confirm your email address within " + emailVerificationTTL.String() + ":\n\n" + token + "\n",
+	})
+}
+
+// VerifyEmail confirms the profile email with the mailed token; it needs no session so links work anywhere.
+func (ws wrapperStruct) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var body datamodels.EmailVerification
+	err := decodeJSON(w, r, &body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Check(body.Token != "", "token", "required", "token is required")
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err = ws.Users.VerifyEmail(r.Context(), utils.GetSHA256Hash(body.Token)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/N0rkton/gophermart/internal/apierror"
+)
+
+const maxBodySize = 1 << 20
+
+var (
+	ErrUnsupportedMediaType = apierror.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "unsupported content type")
+	ErrBodyTooLarge         = apierror.New(http.StatusRequestEntityTooLarge, "body_too_large", "request body is too large")
+)
+
+func requireContentType(r *http.Request, want string) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != want {
+		return ErrUnsupportedMediaType.WithFields(apierror.FieldError{Field: "Content-Type", Code: "content_type", Message: "expected " + want})
+	}
+	return nil
+}
+
+// readBodyError reports an oversized body as ErrBodyTooLarge and any other read failure as fallback.
+func readBodyError(err error, fallback error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return ErrBodyTooLarge
+	}
+	return fallback
+}
+
+// decodeJSON reads a size-limited application/json body into dst, rejecting unknown fields and trailing data.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	if err := requireContentType(r, "application/json"); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return readBodyError(err, apierror.ErrInvalidJSON)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return apierror.ErrInvalidJSON
+	}
+	return nil
+}
+
+// readText reads a size-limited text/plain body with surrounding whitespace trimmed.
+func readText(w http.ResponseWriter, r *http.Request) (string, error) {
+	if err := requireContentType(r, "text/plain"); err != nil {
+		return "", err
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return "", readBodyError(err, apierror.ErrInvalidBody)
+	}
+	return string(bytes.TrimSpace(body)), nil
+}
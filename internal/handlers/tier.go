@@ -0,0 +1,18 @@
+package handlers
+
+import "net/http"
+
+// Tier shows the user's loyalty tier, the progress to the next tier and past tier changes.
+func (ws wrapperStruct) Tier(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tier, err := ws.DB.GetTier(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, tier)
+}
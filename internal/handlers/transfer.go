@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/validation"
+)
+
+// Transfer moves points to another user; sums above the withdrawal threshold need a fresh one-time code.
+func (ws wrapperStruct) Transfer(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.TransferRequest
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Check(body.To != "", "to", "required", "recipient login is required")
+	v.Sum("sum", body.Sum)
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.Sum > ws.otpThreshold {
+		if err = ws.requireFreshOTP(r, id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	transfer, err := ws.DB.Transfer(r.Context(), id, body.To, body.Sum, ws.transferLimit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionTransfer, body.To, nil, transfer); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, transfer)
+}
+
+func (ws wrapperStruct) Transfers(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	transfers, err := ws.DB.GetTransfers(r.Context(), id)
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, transfers)
+}
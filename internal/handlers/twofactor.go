@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/bruteforce"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/totp"
+	"github.com/N0rkton/gophermart/internal/utils"
+	"github.com/N0rkton/gophermart/internal/validation"
+)
+
+const (
+	totpIssuer         = "Gophermart"
+	recoveryCodesCount = 10
+	pendingLoginTTL    = 5 * time.Minute
+	otpHeader          = "X-OTP-Code"
+)
+
+// TwoFactorEnroll stores a new TOTP secret and returns it with its otpauth URI;
+// two-factor authentication stays off until TwoFactorEnable confirms a code.
+func (ws wrapperStruct) TwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	account, err := ws.currentAccount(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	secret := totp.NewSecret()
+	if err = ws.DB.SetTOTPSecret(r.Context(), account.ID, secret); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, datamodels.TOTPEnrollment{Secret: secret, URI: totp.URI(totpIssuer, account.Login, secret)})
+}
+
+// TwoFactorEnable confirms the enrolled secret with a code and returns the recovery codes, shown only once.
+func (ws wrapperStruct) TwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.SecondFactor
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	v.Check(body.Code != "", "code", "required", "code is required")
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tf, err := ws.DB.GetTwoFactor(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if tf.Enabled {
+		writeError(w, r, storage.ErrTwoFactorEnabled)
+		return
+	}
+	if tf.Secret == "" {
+		writeError(w, r, ErrTwoFactorNotEnrolled)
+		return
+	}
+	key := bruteforce.TOTPKey(id)
+	if err = ws.guard.Attempt(r.Context(), key, ws.limits.login); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	step, ok := totp.Validate(tf.Secret, body.Code, time.Now())
+	if !ok {
+		writeError(w, r, storage.ErrInvalidTOTP)
+		return
+	}
+	ws.secondFactorPassed(r, key)
+	codes := totp.NewRecoveryCodes(recoveryCodesCount)
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+	if err = ws.DB.EnableTwoFactor(r.Context(), id, step, hashes); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionTwoFactorEnable, "", nil, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, datamodels.RecoveryCodes{Codes: codes})
+}
+
+// TwoFactorDisable turns two-factor authentication off after checking a code or a recovery code.
+func (ws wrapperStruct) TwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.SecondFactor
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tf, err := ws.DB.GetTwoFactor(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !tf.Enabled {
+		writeError(w, r, ErrTwoFactorNotEnabled)
+		return
+	}
+	if err = ws.checkSecondFactor(r, id, tf, body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.DB.DisableTwoFactor(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.audit(r, id, audit.ActionTwoFactorDisable, "", nil, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// LoginSecondFactor completes a login pending on two-factor authentication and issues the session cookie.
+func (ws wrapperStruct) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var body datamodels.LoginSecondFactor
+	err := decodeJSON(w, r, &body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := ws.pending.GetPending(body.Token)
+	if err != nil {
+		writeError(w, r, ErrMFATokenInvalid)
+		return
+	}
+	tf, err := ws.DB.GetTwoFactor(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.checkSecondFactor(r, id, tf, body.SecondFactor); err != nil {
+		ws.audit(r, id, audit.ActionLoginFailed, "", nil, map[string]string{"reason": "second_factor"})
+		writeError(w, r, err)
+		return
+	}
+	ws.pending.DeletePending(body.Token)
+	if err = ws.audit(r, id, audit.ActionLogin, "", nil, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = ws.startSession(w, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// startPendingLogin answers a correct password of a 2FA account with a token for LoginSecondFactor.
+func (ws wrapperStruct) startPendingLogin(w http.ResponseWriter, r *http.Request, id int) {
+	token := utils.GenerateRandomString(20)
+	if err := ws.pending.AddPending(token, id, pendingLoginTTL); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusAccepted, datamodels.PendingLogin{Token: token, ExpiresIn: int(pendingLoginTTL / time.Second)})
+}
+
+// requireFreshOTP demands a TOTP code in the X-OTP-Code header from users with two-factor authentication.
+func (ws wrapperStruct) requireFreshOTP(r *http.Request, id int) error {
+	tf, err := ws.DB.GetTwoFactor(r.Context(), id)
+	if err != nil || !tf.Enabled {
+		return err
+	}
+	code := r.Header.Get(otpHeader)
+	if code == "" {
+		return ErrOTPRequired
+	}
+	return ws.checkSecondFactor(r, id, tf, datamodels.SecondFactor{Code: code})
+}
+
+// checkSecondFactor verifies a TOTP code, which must not have been used before, or consumes a recovery code.
+// Every attempt counts towards the per-user lockout until one succeeds.
+func (ws wrapperStruct) checkSecondFactor(r *http.Request, id int, tf datamodels.TwoFactor, f datamodels.SecondFactor) error {
+	key := bruteforce.TOTPKey(id)
+	if err := ws.guard.Attempt(r.Context(), key, ws.limits.login); err != nil {
+		return err
+	}
+	var err error
+	switch {
+	case f.Code != "":
+		step, ok := totp.Validate(tf.Secret, f.Code, time.Now())
+		err = storage.ErrInvalidTOTP
+		if ok {
+			err = ws.DB.UseTOTPStep(r.Context(), id, step)
+		}
+	case f.RecoveryCode != "":
+		err = ws.DB.UseRecoveryCode(r.Context(), id, totp.HashRecoveryCode(f.RecoveryCode))
+		if err == nil {
+			err = ws.audit(r, id, audit.ActionRecoveryCodeUsed, "", nil, nil)
+		}
+	default:
+		err = storage.ErrInvalidTOTP
+	}
+	if err == nil {
+		ws.secondFactorPassed(r, key)
+	}
+	return err
+}
+
+func (ws wrapperStruct) secondFactorPassed(r *http.Request, key string) {
+	if err := ws.guard.Reset(r.Context(), key); err != nil {
+		log.WarnContext(r.Context(), "reset second factor attempts", "err", err)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/storage"
+	"github.com/N0rkton/gophermart/internal/utils"
+	"github.com/N0rkton/gophermart/internal/validation"
+	"github.com/N0rkton/gophermart/internal/webhook"
+	"github.com/gorilla/mux"
+)
+
+const maxDeliveriesListed = 100
+
+var webhookEventTypes = map[string]bool{
+	datamodels.EventOrderStatusChanged: true,
+	datamodels.EventWithdrawalPosted:   true,
+	datamodels.EventWithdrawalRefunded: true,
+}
+
+// CreateWebhook registers an endpoint; the signing secret is returned only in this response.
+func (ws wrapperStruct) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body datamodels.NewWebhook
+	if err = decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	v := validation.New()
+	switch err = webhook.CheckURL(r.Context(), body.URL); {
+	case errors.Is(err, webhook.ErrForbiddenAddress):
+		v.Check(false, "url", "forbidden_address", "url must not point to a loopback, private or link-local address")
+	case err != nil:
+		v.Check(false, "url", "url", "url must be an absolute http or https URL with a resolvable host")
+	}
+	v.Check(len(body.EventTypes) > 0, "event_types", "required", "at least one event type is required")
+	for _, t := range body.EventTypes {
+		v.Check(webhookEventTypes[t], "event_types", "unknown", "unknown event type "+strconv.Quote(t))
+	}
+	if err = v.Err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	endpoint, err := ws.DB.CreateWebhook(r.Context(), datamodels.WebhookEndpoint{
+		UserID:     id,
+		URL:        body.URL,
+		Secret:     utils.GenerateRandomString(20),
+		EventTypes: body.EventTypes,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusCreated, endpoint)
+}
+
+func (ws wrapperStruct) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	endpoints, err := ws.DB.GetWebhooks(r.Context(), id)
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, endpoints)
+}
+
+func (ws wrapperStruct) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	webhookID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err = ws.DB.DeleteWebhook(r.Context(), id, webhookID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries shows recent deliveries; ?status=DEAD lists the dead letters.
+func (ws wrapperStruct) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	deliveries, err := ws.DB.GetWebhookDeliveries(r.Context(), id, r.URL.Query().Get("status"), maxDeliveriesListed)
+	if errors.Is(err, storage.ErrNoData) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, deliveries)
+}
+
+// ReplayWebhookDelivery queues a delivery, typically a dead letter, to be sent again.
+func (ws wrapperStruct) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := ws.currentUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	deliveryID, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err = ws.DB.ReplayWebhookDelivery(r.Context(), id, deliveryID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
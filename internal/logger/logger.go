@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values never reach the log output.
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"authorization": true,
+	"secret":        true,
+	"token":         true,
+}
+
+var (
+	mu       sync.Mutex
+	defLevel = new(slog.LevelVar)
+	levels   = make(map[string]*slog.LevelVar)
+	base     = newJSONHandler(os.Stdout)
+)
+
+func init() {
+	slog.SetDefault(slog.New(&contextHandler{next: base, level: defLevel}))
+}
+
+func newJSONHandler(w io.Writer) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       slog.LevelDebug,
+		ReplaceAttr: redact,
+	})
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// Init applies a level spec such as "info,handlers=debug,storage=warn":
+// a bare level sets the default, pkg=level overrides it for one package.
+func Init(spec string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pkg, lvl, ok := strings.Cut(part, "=")
+		if !ok {
+			lvl, pkg = pkg, ""
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(lvl)); err != nil {
+			return fmt.Errorf("log level %q: %w", part, err)
+		}
+		if pkg == "" {
+			defLevel.Set(level)
+			continue
+		}
+		levelFor(pkg).Set(level)
+	}
+	return nil
+}
+
+func levelFor(pkg string) *slog.LevelVar {
+	lv, ok := levels[pkg]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(defLevel.Level())
+		levels[pkg] = lv
+	}
+	return lv
+}
+
+// For returns the logger of a package; its level can be changed later by Init.
+func For(pkg string) *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	return slog.New(&contextHandler{next: base, level: &pkgLevel{pkg: pkg}}).With("pkg", pkg)
+}
+
+// pkgLevel resolves to the package override when one is set and to the default level otherwise.
+type pkgLevel struct {
+	pkg string
+}
+
+func (l *pkgLevel) Level() slog.Level {
+	mu.Lock()
+	lv, ok := levels[l.pkg]
+	mu.Unlock()
+	if ok {
+		return lv.Level()
+	}
+	return defLevel.Level()
+}
+
+// contextHandler filters by level and adds the request and trace IDs stored in the context to every record.
+type contextHandler struct {
+	next  slog.Handler
+	level slog.Leveler
+}
+
+func (h *contextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{next: h.next.WithAttrs(attrs), level: h.level}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name), level: h.level}
+}
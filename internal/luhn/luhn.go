@@ -0,0 +1,56 @@
+package luhn
+
+import "errors"
+
+var ErrNotDigits = errors.New("luhn: input must be a non-empty string of ASCII digits")
+
+// sum returns the Luhn sum of number; when withCheck is false the number is
+// treated as a payload whose check digit is still to be appended.
+func sum(number string, withCheck bool) (int, error) {
+	if number == "" {
+		return 0, ErrNotDigits
+	}
+	var total int
+	double := !withCheck
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			return 0, ErrNotDigits
+		}
+		cur := int(c - '0')
+		if double {
+			cur *= 2
+			if cur > 9 {
+				cur -= 9
+			}
+		}
+		total += cur
+		double = !double
+	}
+	return total, nil
+}
+
+// Valid reports whether number is a digit string with a correct Luhn check digit.
+// Leading zeros are significant and the length is not limited.
+func Valid(number string) bool {
+	total, err := sum(number, true)
+	return err == nil && total%10 == 0
+}
+
+// CheckDigit computes the check digit to append to payload.
+func CheckDigit(payload string) (byte, error) {
+	total, err := sum(payload, false)
+	if err != nil {
+		return 0, err
+	}
+	return byte('0' + (10-total%10)%10), nil
+}
+
+// Append returns payload followed by its check digit.
+func Append(payload string) (string, error) {
+	d, err := CheckDigit(payload)
+	if err != nil {
+		return "", err
+	}
+	return payload + string(d), nil
+}
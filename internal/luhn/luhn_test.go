@@ -0,0 +1,99 @@
+package luhn
+
+import (
+	"strconv"
+	"testing"
+)
+
+// referenceValid is the textbook Luhn check: double every second digit from the right
+// and add up the digits of the products.
+func referenceValid(number string) bool {
+	if number == "" {
+		return false
+	}
+	var digits []int
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return false
+		}
+		digits = append(digits, int(r-'0'))
+	}
+	total := 0
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			d *= 2
+			d = d/10 + d%10
+		}
+		total += d
+	}
+	return total%10 == 0
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"", false},
+		{"0", true},
+		{"00", true},
+		{"18", true},
+		{"79927398713", true},
+		{"79927398710", false},
+		{"4561261212345467", true},
+		{"4561261212345464", false},
+		{"12345678903", true},
+		{"1234567890", false},
+		{"9278923470", true},
+		{"0079927398713", true},
+		{"7992739871a", false},
+		{" 79927398713", false},
+		{"-18", false},
+		{"１８", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.number); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+		err     error
+	}{
+		{"7992739871", "79927398713", nil},
+		{"0", "00", nil},
+		{"123456789", "1234567897", nil},
+		{"", "", ErrNotDigits},
+		{"12a", "", ErrNotDigits},
+	}
+	for _, tt := range tests {
+		got, err := Append(tt.payload)
+		if got != tt.want || err != tt.err {
+			t.Errorf("Append(%q) = %q, %v, want %q, %v", tt.payload, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func FuzzValid(f *testing.F) {
+	for _, seed := range []string{"", "0", "18", "79927398713", "79927398710", "4561261212345467", "12a4", "١٢٣"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, number string) {
+		if got, want := Valid(number), referenceValid(number); got != want {
+			t.Fatalf("Valid(%q) = %v, reference %v", number, got, want)
+		}
+		if d, err := CheckDigit(number); err == nil {
+			if !Valid(number + string(d)) {
+				t.Fatalf("CheckDigit(%q) = %c does not validate", number, d)
+			}
+			if !referenceValid(number + strconv.Itoa(int(d-'0'))) {
+				t.Fatalf("reference rejects %q with check digit %c", number, d)
+			}
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "query_duration_seconds",
+		Help:      "Storage call latency by method and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "outcome"})
+	accrualRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "requests_total",
+		Help:      "Requests to the accrual system by response status code.",
+	}, []string{"code"})
+	pollBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "poll_batch_size",
+		Help:      "Number of orders checked in one accrual poll.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	pollDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "poll_duration_seconds",
+		Help:      "Duration of one accrual poll.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	ordersByStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orders",
+		Help:      "Number of orders by status.",
+	}, []string{"status"})
+	pointsAccrued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_accrued_total",
+		Help:      "Total points credited to users.",
+	})
+	pointsWithdrawn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Total points withdrawn by users.",
+	})
+	pointsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_expired_total",
+		Help:      "Total points expired unspent.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, dbDuration, accrualRequests,
+		pollBatchSize, pollDuration, ordersByStatus, pointsAccrued, pointsWithdrawn, pointsExpired)
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Middleware records request count and latency labelled with the mux route template.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		route := "unmatched"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		status := strconv.Itoa(rec.status)
+		httpRequests.WithLabelValues(route, r.Method, status).Inc()
+		httpDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func ObserveDB(method string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	dbDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
+
+// AccrualResponse counts a response from the accrual system; code 0 means the request failed.
+func AccrualResponse(code int) {
+	label := strconv.Itoa(code)
+	if code == 0 {
+		label = "error"
+	}
+	accrualRequests.WithLabelValues(label).Inc()
+}
+
+func ObservePoll(batch int, start time.Time) {
+	pollBatchSize.Observe(float64(batch))
+	pollDuration.Observe(time.Since(start).Seconds())
+}
+
+func SetOrdersByStatus(counts map[string]int) {
+	ordersByStatus.Reset()
+	for status, n := range counts {
+		ordersByStatus.WithLabelValues(status).Set(float64(n))
+	}
+}
+
+func AddAccrued(points float64) {
+	pointsAccrued.Add(points)
+}
+
+func AddWithdrawn(points float64) {
+	pointsWithdrawn.Add(points)
+}
+
+func AddExpired(points float64) {
+	pointsExpired.Add(points)
+}
@@ -0,0 +1,147 @@
+// Package notify delivers messages to users through pluggable channels.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/logger"
+)
+
+var log = logger.For("notify")
+
+// Message is addressed to a user; To is an email address or, for users without one, their login.
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// PasswordReset is the mail carrying a password reset token valid for ttl.
+func PasswordReset(to, token string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Gophermart password reset",
+		Body: "Use this token to reset your password within " + ttl.String() + ":\n\n" + token +
+			"\n\nIf you did not ask for a reset, ignore this message.\n",
+	}
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+// Parse builds the notifier described by spec: log, file:<path> or smtp://[user:password@]host:port?from=<address>[&domain=<domain>].
+func Parse(spec string) (Notifier, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch kind {
+	case "", "log":
+		return LogNotifier{}, nil
+	case "file":
+		return NewFileNotifier(arg)
+	case "smtp":
+		return ParseSMTP(spec)
+	}
+	return nil, fmt.Errorf("unknown notifier %q", spec)
+}
+
+// LogNotifier is the default until a channel is configured: it logs that a message was not
+// delivered, leaving out the body, which carries secrets such as reset tokens. Use file:<path>
+// to read messages in development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, m Message) error {
+	log.WarnContext(ctx, "notification not delivered, no notifier configured", "to", m.To, "subject", m.Subject)
+	return nil
+}
+
+// FileNotifier appends messages as JSON lines to a file.
+type FileNotifier struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileNotifier{f: f}, nil
+}
+
+func (n *FileNotifier) Notify(_ context.Context, m Message) error {
+	if m.SentAt.IsZero() {
+		m.SentAt = time.Now().UTC()
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, err = n.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return n.f.Sync()
+}
+
+func (n *FileNotifier) Close() error {
+	return n.f.Close()
+}
+
+// SMTPNotifier sends messages as plain text mail. Recipients without an address
+// are mailed at <login>@<domain> when a domain is configured.
+type SMTPNotifier struct {
+	addr   string
+	from   string
+	domain string
+	auth   smtp.Auth
+}
+
+func NewSMTPNotifier(addr string, from string, domain string, auth smtp.Auth) *SMTPNotifier {
+	return &SMTPNotifier{addr: addr, from: from, domain: domain, auth: auth}
+}
+
+func ParseSMTP(spec string) (*SMTPNotifier, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid smtp notifier %q", spec)
+	}
+	from := u.Query().Get("from")
+	if from == "" {
+		return nil, fmt.Errorf("smtp notifier %q needs a from address", u.Redacted())
+	}
+	var auth smtp.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+	}
+	return NewSMTPNotifier(u.Host, from, u.Query().Get("domain"), auth), nil
+}
+
+func (n *SMTPNotifier) Notify(_ context.Context, m Message) error {
+	to := m.To
+	if !strings.Contains(to, "@") {
+		if n.domain == "" {
+			return fmt.Errorf("no email address for %q", to)
+		}
+		to += "@" + n.domain
+	}
+	if strings.ContainsAny(to+m.Subject, "\r\n") {
+		return fmt.Errorf("invalid message header")
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", n.from, to, m.Subject, time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{to}, msg.Bytes())
+}
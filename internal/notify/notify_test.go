@@ -0,0 +1,81 @@
+package notify_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/notify"
+	"github.com/N0rkton/gophermart/internal/notify/smtptest"
+)
+
+func newSMTP(t *testing.T, query string) (notify.Notifier, *smtptest.Server) {
+	t.Helper()
+	srv, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	n, err := notify.Parse("smtp://" + srv.Addr + "?from=noreply@gophermart.test" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, srv
+}
+
+func TestSMTPPasswordReset(t *testing.T) {
+	n, srv := newSMTP(t, "")
+	const token = "Zq7rX2mB9kLw4TnV8pYc"
+	if err := n.Notify(context.Background(), notify.PasswordReset("alice@example.com", token, 30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.Wait(1, 5*time.Second) {
+		t.Fatal("no mail arrived")
+	}
+	m := srv.Mails()[0]
+	if m.From != "noreply@gophermart.test" {
+		t.Errorf("from = %q", m.From)
+	}
+	if len(m.To) != 1 || m.To[0] != "alice@example.com" {
+		t.Errorf("to = %q, want [alice@example.com]", m.To)
+	}
+	header, body, ok := strings.Cut(m.Data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no header separator in %q", m.Data)
+	}
+	if !strings.Contains(header, "To: alice@example.com\r\n") || !strings.Contains(header, "Subject: Gophermart password reset\r\n") {
+		t.Errorf("header = %q", header)
+	}
+	if !strings.Contains(body, "\r\n"+token+"\r\n") {
+		t.Errorf("body %q does not carry the token on its own line", body)
+	}
+}
+
+func TestSMTPLoginRecipient(t *testing.T) {
+	n, srv := newSMTP(t, "&domain=example.com")
+	if err := n.Notify(context.Background(), notify.PasswordReset("bob", "token", time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.Wait(1, 5*time.Second) {
+		t.Fatal("no mail arrived")
+	}
+	if to := srv.Mails()[0].To; len(to) != 1 || to[0] != "bob@example.com" {
+		t.Errorf("to = %q, want [bob@example.com]", to)
+	}
+}
+
+func TestSMTPRejects(t *testing.T) {
+	n, srv := newSMTP(t, "")
+	for _, m := range []notify.Message{
+		notify.PasswordReset("bob", "token", time.Minute),
+		{To: "eve@example.com\r\nBcc: mallory@example.com", Subject: "x"},
+	} {
+		if err := n.Notify(context.Background(), m); err == nil {
+			t.Errorf("Notify(%q) succeeded", m.To)
+		}
+	}
+	if mails := srv.Mails(); len(mails) != 0 {
+		t.Errorf("sent %d mails", len(mails))
+	}
+}
@@ -0,0 +1,142 @@
+// Package smtptest provides a local SMTP server recording mail for integration tests.
+package smtptest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail is a message accepted by the Server.
+type Mail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Server speaks just enough SMTP for net/smtp clients without TLS or authentication.
+type Server struct {
+	Addr string
+	ln   net.Listener
+
+	mu      sync.Mutex
+	mails   []Mail
+	arrived chan struct{}
+}
+
+// NewServer starts a server on a random local port; call Close when done.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: ln.Addr().String(), ln: ln, arrived: make(chan struct{}, 1)}
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.ln.Close()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 smtptest ready")
+	var mail Mail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 smtptest")
+		case "MAIL":
+			mail = Mail{From: address(line)}
+			reply("250 OK")
+		case "RCPT":
+			mail.To = append(mail.To, address(line))
+			reply("250 OK")
+		case "DATA":
+			reply("354 end with <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			mail.Data = data.String()
+			s.record(mail)
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func address(line string) string {
+	_, arg, _ := strings.Cut(line, ":")
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Trim(fields[0], "<>")
+}
+
+func (s *Server) record(m Mail) {
+	s.mu.Lock()
+	s.mails = append(s.mails, m)
+	s.mu.Unlock()
+	select {
+	case s.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// Mails returns the messages received so far.
+func (s *Server) Mails() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Mail(nil), s.mails...)
+}
+
+// Wait blocks until at least n messages arrived or timeout passes and reports whether they did.
+func (s *Server) Wait(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for len(s.Mails()) < n {
+		select {
+		case <-s.arrived:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+// Package outbox relays domain events from the storage outbox to pluggable sinks.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/domain"
+	"github.com/N0rkton/gophermart/internal/logger"
+)
+
+var log = logger.For("outbox")
+
+// Sink receives published domain events. Delivery is at least once, so sinks and
+// their consumers should de-duplicate by Event.ID.
+type Sink interface {
+	Publish(ctx context.Context, events []domain.Event) error
+}
+
+// Store is the part of the storage the relay works with.
+type Store interface {
+	PublishOutbox(ctx context.Context, limit int, lease time.Duration, publish func([]domain.Event) error) (int, error)
+}
+
+// Relay periodically moves unpublished outbox events to all sinks.
+type Relay struct {
+	store     Store
+	sinks     []Sink
+	interval  time.Duration
+	batchSize int
+	// lease is how long a batch stays claimed by this relay while the sinks publish it.
+	lease time.Duration
+}
+
+func NewRelay(store Store, interval time.Duration, sinks ...Sink) *Relay {
+	return &Relay{store: store, sinks: sinks, interval: interval, batchSize: 100, lease: 2 * time.Minute}
+}
+
+// Run relays events until ctx is done; a failing sink keeps the batch in the outbox for the next round.
+func (rl *Relay) Run(ctx context.Context) {
+	if len(rl.sinks) == 0 {
+		return
+	}
+	ticker := time.NewTicker(rl.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := rl.store.PublishOutbox(ctx, rl.batchSize, rl.lease, func(events []domain.Event) error {
+				for _, sink := range rl.sinks {
+					if err := sink.Publish(ctx, events); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.WarnContext(ctx, "outbox relay", "err", err)
+				break
+			}
+			if n < rl.batchSize {
+				break
+			}
+		}
+	}
+}
+
+// ParseSinks builds sinks from a comma-separated spec of "log", "file:<path>" and "webhook:<url>".
+func ParseSinks(spec string, webhookSecret string) ([]Sink, error) {
+	var sinks []Sink
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		kind, arg, _ := strings.Cut(part, ":")
+		switch kind {
+		case "", "none":
+		case "log":
+			sinks = append(sinks, LogSink{})
+		case "file":
+			sink, err := NewFileSink(arg)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "webhook":
+			sinks = append(sinks, NewWebhookSink(arg, webhookSecret))
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q", part)
+		}
+	}
+	return sinks, nil
+}
@@ -0,0 +1,128 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/domain"
+	"github.com/N0rkton/gophermart/internal/webhook"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// LogSink writes every event to the structured log.
+type LogSink struct{}
+
+func (LogSink) Publish(ctx context.Context, events []domain.Event) error {
+	for _, e := range events {
+		log.InfoContext(ctx, "domain event", "id", e.ID, "type", e.Type, "order", e.AggregateID, "user", e.UserID, "payload", string(e.Payload))
+	}
+	return nil
+}
+
+// FileSink appends events as JSON lines to a file.
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Publish(_ context.Context, events []domain.Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// WebhookSink posts each batch as a JSON array to a single URL, signed like user webhooks.
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookSink(url string, secret string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+	}
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, events []domain.Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.HeaderTimestamp, timestamp)
+	req.Header.Set(webhook.HeaderSignature, webhook.Sign(s.secret, timestamp, body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("outbox webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Publisher is the subset of a NATS connection used by NATSSink; *nats.Conn satisfies it.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSSink publishes every event to "<prefix>.<type>" with the event JSON as data.
+type NATSSink struct {
+	pub    Publisher
+	prefix string
+}
+
+func NewNATSSink(pub Publisher, prefix string) *NATSSink {
+	return &NATSSink{pub: pub, prefix: prefix}
+}
+
+func (s *NATSSink) Publish(_ context.Context, events []domain.Event) error {
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err = s.pub.Publish(s.prefix+"."+e.Type, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
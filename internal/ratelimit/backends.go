@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+type memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewMemory returns a backend keeping buckets in process; limits are per replica.
+func NewMemory() Backend {
+	return &memory{buckets: make(map[string]*bucket), swept: time.Now()}
+}
+
+const sweepInterval = time.Minute
+
+func (m *memory) Take(_ context.Context, key string, l Limit) (Result, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.swept) > sweepInterval {
+		m.sweep(now)
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	var allowed bool
+	b.tokens, allowed = refill(b.tokens, now.Sub(b.updated), l)
+	b.updated = now
+	b.full = now.Add(time.Duration((float64(l.Burst) - b.tokens) / l.Rate * float64(time.Second)))
+	return Result{Allowed: allowed, Remaining: b.tokens}, nil
+}
+
+// sweep drops buckets that have refilled completely.
+func (m *memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if now.After(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+	m.swept = now
+}
+
+type postgres struct {
+	store Store
+}
+
+// NewPostgres returns a backend keeping buckets in the database so limits hold across replicas.
+func NewPostgres(store Store) Backend {
+	return &postgres{store: store}
+}
+
+func (p *postgres) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	tokens, allowed, err := p.store.TakeRateToken(ctx, key, l.Rate, l.Burst)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: allowed, Remaining: tokens}, nil
+}
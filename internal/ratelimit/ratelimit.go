@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/apierror"
+	"github.com/N0rkton/gophermart/internal/logger"
+	"github.com/gorilla/mux"
+)
+
+var log = logger.For("ratelimit")
+
+var ErrRateLimited = apierror.New(http.StatusTooManyRequests, "rate_limited", "too many requests")
+
+// Limit is a token bucket refilled with Rate tokens per second and holding at most Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of taking one token from a bucket.
+type Result struct {
+	Allowed   bool
+	Remaining float64
+}
+
+// Backend keeps the token buckets.
+type Backend interface {
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+// Store persists token buckets shared by all replicas.
+type Store interface {
+	TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error)
+}
+
+// NewBackend returns the backend named kind: memory or postgres.
+func NewBackend(kind string, store Store) (Backend, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemory(), nil
+	case "postgres":
+		return NewPostgres(store), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit backend %q", kind)
+}
+
+// ParseLimits parses comma separated <route>=<n>/<period>[:<burst>] entries, e.g. "/api/user/orders=60/1m:20".
+// The burst defaults to n.
+func ParseLimits(spec string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q", part)
+		}
+		value, burstStr, hasBurst := strings.Cut(value, ":")
+		countStr, periodStr, ok := strings.Cut(value, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q", part)
+		}
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q", part)
+		}
+		period, err := time.ParseDuration(periodStr)
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("invalid rate limit period %q", part)
+		}
+		burst := count
+		if hasBurst {
+			if burst, err = strconv.Atoi(burstStr); err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid rate limit burst %q", part)
+			}
+		}
+		limits[strings.TrimSpace(route)] = Limit{Rate: float64(count) / period.Seconds(), Burst: burst}
+	}
+	return limits, nil
+}
+
+// Limiter applies the limit configured for the matched mux route to each client.
+type Limiter struct {
+	backend Backend
+	limits  map[string]Limit
+	client  func(r *http.Request) string
+}
+
+// New returns a limiter; client identifies the caller, e.g. by user ID or address.
+func New(backend Backend, limits map[string]Limit, client func(r *http.Request) string) *Limiter {
+	return &Limiter{backend: backend, limits: limits, client: client}
+}
+
+// Middleware rejects requests over the route limit with 429 and reports the quota in RateLimit-* headers.
+// Backend failures let the request through.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tpl, err := route.GetPathTemplate()
+		limit, ok := l.limits[tpl]
+		if err != nil || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		res, err := l.backend.Take(r.Context(), tpl+"|"+l.client(r), limit)
+		if err != nil {
+			log.WarnContext(r.Context(), "rate limit backend", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(int(res.Remaining)))
+		h.Set("RateLimit-Reset", seconds((float64(limit.Burst)-res.Remaining)/limit.Rate))
+		if !res.Allowed {
+			h.Set("Retry-After", seconds((1-res.Remaining)/limit.Rate))
+			apierror.Write(w, r, ErrRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func seconds(s float64) string {
+	return strconv.Itoa(int(math.Ceil(math.Max(s, 0))))
+}
+
+// refill returns the tokens in a bucket holding tokens elapsed ago and takes one if possible.
+func refill(tokens float64, elapsed time.Duration, l Limit) (float64, bool) {
+	tokens = math.Min(float64(l.Burst), tokens+elapsed.Seconds()*l.Rate)
+	if tokens < 1 {
+		return tokens, false
+	}
+	return tokens - 1, true
+}
@@ -0,0 +1,58 @@
+package sessionstorage
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// PendingStorage keeps short-lived logins that passed the password check and wait for a second factor.
+type PendingStorage interface {
+	AddPending(token string, id int, ttl time.Duration) error
+	GetPending(token string) (int, error)
+	DeletePending(token string)
+}
+
+type pendingLogin struct {
+	id      int
+	expires time.Time
+}
+
+type pendingStorage struct {
+	logins map[string]pendingLogin
+	mutex  sync.Mutex
+}
+
+func NewPendingStorage() PendingStorage {
+	return &pendingStorage{logins: make(map[string]pendingLogin)}
+}
+
+func (ps *pendingStorage) AddPending(token string, id int, ttl time.Duration) error {
+	now := time.Now()
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	for t, l := range ps.logins {
+		if now.After(l.expires) {
+			delete(ps.logins, t)
+		}
+	}
+	ps.logins[token] = pendingLogin{id: id, expires: now.Add(ttl)}
+	return nil
+}
+
+func (ps *pendingStorage) GetPending(token string) (int, error) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	l, ok := ps.logins[token]
+	if !ok || time.Now().After(l.expires) {
+		delete(ps.logins, token)
+		return 0, errors.New("pending login not found")
+	}
+	return l.id, nil
+}
+
+func (ps *pendingStorage) DeletePending(token string) {
+	ps.mutex.Lock()
+	delete(ps.logins, token)
+	ps.mutex.Unlock()
+}
@@ -8,6 +8,8 @@ import (
 type SessionStorage interface {
 	AddUser(user string, id int) error
 	GetUser(user string) (int, error)
+	// RevokeUser ends all sessions of id except keep and returns how many were ended.
+	RevokeUser(id int, keep string) int
 }
 type authUsersStorage struct {
 	authUsers map[string]int
@@ -32,3 +34,15 @@ func (us *authUsersStorage) GetUser(user string) (int, error) {
 	}
 	return id, nil
 }
+func (us *authUsersStorage) RevokeUser(id int, keep string) int {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	n := 0
+	for user, userID := range us.authUsers {
+		if userID == id && user != keep {
+			delete(us.authUsers, user)
+			n++
+		}
+	}
+	return n
+}
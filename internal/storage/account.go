@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/lib/pq"
+)
+
+// DeleteAccount anonymises the user and removes their credentials and settings. Ledger rows stay
+// with the user until PurgeDeletedUsers removes the row and detaches them.
+func (dbs *DBStorage) DeleteAccount(ctx context.Context, userID int) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `update users set login='deleted user '||id, password='', blocked=true, deleted_at=now(),
+		totp_secret=null, totp_enabled=false, totp_last_step=null where id=$1 and deleted_at is null;`, userID)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	for _, q := range []string{
+		"delete from webhook_endpoints where user_id=$1;",
+		"delete from recovery_codes where user_id=$1;",
+		"delete from password_resets where user_id=$1;",
+		"delete from user_profiles where user_id=$1;",
+		"delete from email_verifications where user_id=$1;",
+		"delete from api_keys where user_id=$1;",
+		"delete from tier_history where user_id=$1;",
+	} {
+		if _, err = tx.ExecContext(ctx, q, userID); err != nil {
+			return ErrInternal
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// PurgeDeletedUsers hard-deletes users deleted more than grace ago and returns how many were removed.
+// Their audit entries, and entries naming their login from before the deletion, are pseudonymised
+// in the same transaction; each purge is audited with the number of entries redacted.
+func (dbs *DBStorage) PurgeDeletedUsers(ctx context.Context, grace time.Duration) (int, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, "select id, deleted_at from users where deleted_at < now() - make_interval(secs => $1) order by id for update skip locked;", grace.Seconds())
+	if err != nil {
+		return 0, ErrInternal
+	}
+	type purged struct {
+		id        int
+		deletedAt time.Time
+		redacted  int64
+	}
+	var users []purged
+	for rows.Next() {
+		var u purged
+		if err = rows.Scan(&u.id, &u.deletedAt); err != nil {
+			rows.Close()
+			return 0, ErrInternal
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, ErrInternal
+	}
+	ids := make([]int, len(users))
+	for i := range users {
+		u := &users[i]
+		ids[i] = u.id
+		// The login was replaced on deletion; registration entries still carry it.
+		var logins []string
+		err = tx.QueryRowContext(ctx, "select coalesce(array_agg(distinct target), '{}') from audit_log where actor_id=$1 and action=$2;",
+			u.id, audit.ActionRegister).Scan(pq.Array(&logins))
+		if err != nil {
+			return 0, ErrInternal
+		}
+		res, err := tx.ExecContext(ctx, `update audit_log set target = case when target = any($2) then $4 else target end,
+			ip='', user_agent='', redacted_at=now()
+			where redacted_at is null and created_at <= $3 and (actor_id=$1 or target = any($2));`, u.id, pq.Array(logins), u.deletedAt, deletedLogin(u.id))
+		if err != nil {
+			return 0, ErrInternal
+		}
+		u.redacted, _ = res.RowsAffected()
+	}
+	if _, err = tx.ExecContext(ctx, "delete from users where id = any($1);", pq.Array(ids)); err != nil {
+		return 0, ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, ErrInternal
+	}
+	for _, u := range users {
+		err = dbs.AppendAudit(ctx, audit.Entry{
+			Action: audit.ActionAccountPurge,
+			Target: deletedLogin(u.id),
+			After:  audit.Marshal(map[string]int64{"redacted_entries": u.redacted}),
+		})
+		if err != nil {
+			return len(users), err
+		}
+	}
+	return len(users), nil
+}
+
+// deletedLogin is the pseudonym that replaces the login of a deleted user.
+func deletedLogin(id int) string {
+	return "deleted user " + strconv.Itoa(id)
+}
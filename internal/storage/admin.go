@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/domain"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (dbs *DBStorage) GetUser(ctx context.Context, id int) (datamodels.User, error) {
+	var v datamodels.User
+	err := dbs.db.QueryRowContext(ctx, "select id, login, role, blocked, created_at from users where id=$1;", id).
+		Scan(&v.ID, &v.Login, &v.Role, &v.Blocked, &v.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return datamodels.User{}, ErrNotFound
+	}
+	if err != nil {
+		return datamodels.User{}, ErrInternal
+	}
+	return v, nil
+}
+
+// FindUsers searches users whose login contains the given substring.
+func (dbs *DBStorage) FindUsers(ctx context.Context, login string, limit int) ([]datamodels.User, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(login) + "%"
+	rows, err := dbs.db.QueryContext(ctx, "select id, login, role, blocked, created_at from users where login ilike $1 order by login limit $2;", pattern, limit)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.User
+	for rows.Next() {
+		var tmp datamodels.User
+		if err = rows.Scan(&tmp.ID, &tmp.Login, &tmp.Role, &tmp.Blocked, &tmp.CreatedAt); err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, tmp)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	if resp == nil {
+		return nil, ErrNoData
+	}
+	return resp, nil
+}
+
+func (dbs *DBStorage) SetUserRole(ctx context.Context, login string, role string) error {
+	res, err := dbs.db.ExecContext(ctx, "update users set role=$2 where login=$1;", login, role)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (dbs *DBStorage) SetUserBlocked(ctx context.Context, id int, blocked bool) error {
+	res, err := dbs.db.ExecContext(ctx, "update users set blocked=$2 where id=$1;", id, blocked)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// setOrderStatus moves an uploaded order that has not been credited yet to status.
+func (dbs *DBStorage) setOrderStatus(ctx context.Context, orderID string, status string) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	var userID int
+	var current string
+	err = tx.QueryRowContext(ctx, "select user_id from balance where order_id=$1 and kind='order';", orderID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	// the user's lock comes before the order row, as in UpdateAccrual
+	if err = lockUsers(ctx, tx, userID); err != nil {
+		return ErrInternal
+	}
+	err = tx.QueryRowContext(ctx, "select order_status from balance where order_id=$1 and kind='order' for update;", orderID).Scan(&current)
+	if err != nil {
+		return ErrInternal
+	}
+	if current == "PROCESSED" {
+		return ErrOrderState
+	}
+	if _, err = tx.ExecContext(ctx, "update balance set order_status=$2, accrual=0, remaining=0 where order_id=$1 and kind='order';", orderID, status); err != nil {
+		return ErrInternal
+	}
+	err = recordEvent(ctx, tx, userID, datamodels.EventOrderStatusChanged, orderID, datamodels.OrderStatusEvent{Order: orderID, Status: status})
+	if err != nil {
+		return ErrInternal
+	}
+	err = appendOutbox(ctx, tx, domain.OrderStatusChanged, userID, orderID, domain.OrderStatusChangedPayload{Order: orderID, Status: status})
+	if err != nil {
+		return ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// RequeueOrder resets an order to NEW so the accrual poller checks it again.
+func (dbs *DBStorage) RequeueOrder(ctx context.Context, orderID string) error {
+	return dbs.setOrderStatus(ctx, orderID, "NEW")
+}
+
+func (dbs *DBStorage) InvalidateOrder(ctx context.Context, orderID string) error {
+	return dbs.setOrderStatus(ctx, orderID, "INVALID")
+}
+
+// AdjustBalance credits (positive sum) or debits (negative sum) a user manually;
+// a debit may not exceed the spendable balance.
+func (dbs *DBStorage) AdjustBalance(ctx context.Context, userID int, adminID int, adj datamodels.Adjustment) error {
+	cents := int(math.Round(adj.Sum * 100))
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if err = lockUsers(ctx, tx, userID); err != nil {
+		return ErrInternal
+	}
+	if cents < 0 {
+		available, err := availableCents(ctx, tx, userID)
+		if err != nil {
+			return ErrInternal
+		}
+		if available < -cents {
+			return ErrNotEnoughMoney
+		}
+	}
+	_, err = tx.ExecContext(ctx, "insert into balance_adjustments (user_id, admin_id, amount, reason) values ($1, $2, $3, $4);",
+		userID, adminID, cents, adj.Reason)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+		return ErrNotFound
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	if cents < 0 {
+		if _, err = consumeCredits(ctx, tx, userID, -cents); err != nil {
+			return ErrInternal
+		}
+	}
+	err = appendOutbox(ctx, tx, domain.BalanceAdjusted, userID, "", domain.BalanceAdjustedPayload{Points: adj.Sum, Reason: adj.Reason, AdminID: adminID})
+	if err != nil {
+		return ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
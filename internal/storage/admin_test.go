@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+func TestAdjustBalanceRespectsHolds(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+	admin, _ := newUser(t, dbs)
+	id, _ := newUser(t, dbs)
+	credit(t, dbs, id, 10)
+	if _, err := dbs.CreateHold(ctx, datamodels.OrderInfo{UserID: id, OrderID: newOrder(t), Sum: 6}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbs.AdjustBalance(ctx, id, admin, datamodels.Adjustment{Sum: -5, Reason: "test"}); !errors.Is(err, ErrNotEnoughMoney) {
+		t.Fatalf("debit beyond the unheld balance: %v, want ErrNotEnoughMoney", err)
+	}
+	if err := dbs.AdjustBalance(ctx, id, admin, datamodels.Adjustment{Sum: -4, Reason: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	wantBalance(t, dbs, id, 600)
+	checkLedger(t, dbs, id)
+}
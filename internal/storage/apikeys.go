@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyInvalid  = errors.New("invalid or expired api key")
+)
+
+// APIKeyRepository keeps the API keys users issue for integrations.
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key datamodels.APIKey, keyHash string) (datamodels.APIKey, error)
+	GetAPIKeys(ctx context.Context, userID int) ([]datamodels.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID int, id int) error
+	AuthenticateAPIKey(ctx context.Context, prefix string, keyHash string) (datamodels.APIKey, error)
+}
+
+type dbAPIKeys struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(dbs *DBStorage) APIKeyRepository {
+	return &dbAPIKeys{db: dbs.db}
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, scopes, created_at, last_used_at, expires_at"
+
+func scanAPIKey(row interface{ Scan(...any) error }) (datamodels.APIKey, error) {
+	var v datamodels.APIKey
+	var lastUsed, expires sql.NullTime
+	err := row.Scan(&v.ID, &v.UserID, &v.Name, &v.Prefix, pq.Array(&v.Scopes), &v.CreatedAt, &lastUsed, &expires)
+	if lastUsed.Valid {
+		v.LastUsedAt = &lastUsed.Time
+	}
+	if expires.Valid {
+		v.ExpiresAt = &expires.Time
+	}
+	return v, err
+}
+
+func (ks *dbAPIKeys) CreateAPIKey(ctx context.Context, key datamodels.APIKey, keyHash string) (datamodels.APIKey, error) {
+	v, err := scanAPIKey(ks.db.QueryRowContext(ctx, `insert into api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		values ($1, $2, $3, $4, $5, $6) returning `+apiKeyColumns+`;`,
+		key.UserID, key.Name, key.Prefix, keyHash, pq.Array(key.Scopes), key.ExpiresAt))
+	if err != nil {
+		return datamodels.APIKey{}, ErrInternal
+	}
+	return v, nil
+}
+
+func (ks *dbAPIKeys) GetAPIKeys(ctx context.Context, userID int) ([]datamodels.APIKey, error) {
+	rows, err := ks.db.QueryContext(ctx, "select "+apiKeyColumns+" from api_keys where user_id=$1 order by id;", userID)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.APIKey
+	for rows.Next() {
+		v, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, v)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	if resp == nil {
+		return nil, ErrNoData
+	}
+	return resp, nil
+}
+
+func (ks *dbAPIKeys) DeleteAPIKey(ctx context.Context, userID int, id int) error {
+	res, err := ks.db.ExecContext(ctx, "delete from api_keys where id=$1 and user_id=$2;", id, userID)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// revokeAPIKeys deletes every key of the user; keys issued under a compromised password must not outlive it.
+func revokeAPIKeys(ctx context.Context, tx *sql.Tx, userID int) error {
+	if _, err := tx.ExecContext(ctx, "delete from api_keys where user_id=$1;", userID); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the unexpired key matching prefix and hash and records its use.
+func (ks *dbAPIKeys) AuthenticateAPIKey(ctx context.Context, prefix string, keyHash string) (datamodels.APIKey, error) {
+	v, err := scanAPIKey(ks.db.QueryRowContext(ctx, `update api_keys set last_used_at=now()
+		where prefix=$1 and key_hash=$2 and (expires_at is null or expires_at > now()) returning `+apiKeyColumns+`;`, prefix, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return datamodels.APIKey{}, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return datamodels.APIKey{}, ErrInternal
+	}
+	return v, nil
+}
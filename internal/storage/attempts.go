@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AttemptsLockedUntil returns the latest lockout among keys; the zero time means none is locked.
+func (dbs *DBStorage) AttemptsLockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	var until sql.NullTime
+	err := dbs.db.QueryRowContext(ctx, "select max(locked_until) from auth_attempts where key = any($1) and locked_until > now();", pq.Array(keys)).Scan(&until)
+	if err != nil {
+		return time.Time{}, ErrInternal
+	}
+	return until.Time, nil
+}
+
+// RecordAttempt counts an attempt of key unless it is locked; the row lock taken by the upsert
+// serialises concurrent attempts, so each sees the lock set by the one before.
+func (dbs *DBStorage) RecordAttempt(ctx context.Context, key string, window time.Duration, lockFor func(attempts int) time.Duration) (time.Time, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, ErrInternal
+	}
+	defer tx.Rollback()
+	var failures int
+	var lastFailure, now time.Time
+	var lockedUntil sql.NullTime
+	err = tx.QueryRowContext(ctx, `insert into auth_attempts (key, failures, last_failure_at) values ($1, 0, now())
+		on conflict (key) do update set key = excluded.key
+		returning failures, last_failure_at, locked_until, now();`, key).Scan(&failures, &lastFailure, &lockedUntil, &now)
+	if err != nil {
+		return time.Time{}, ErrInternal
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		return lockedUntil.Time, tx.Commit()
+	}
+	if lastFailure.Before(now.Add(-window)) {
+		failures = 0
+	}
+	failures++
+	lockedUntil = sql.NullTime{}
+	if wait := lockFor(failures); wait > 0 {
+		lockedUntil = sql.NullTime{Time: now.Add(wait), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, "update auth_attempts set failures=$2, last_failure_at=$3, locked_until=$4 where key=$1;", key, failures, now, lockedUntil)
+	if err != nil {
+		return time.Time{}, ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return time.Time{}, ErrInternal
+	}
+	return time.Time{}, nil
+}
+
+func (dbs *DBStorage) ForgiveAttempt(ctx context.Context, key string, lockFor func(attempts int) time.Duration) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	var failures int
+	var lastFailure time.Time
+	err = tx.QueryRowContext(ctx, "select failures, last_failure_at from auth_attempts where key=$1 for update;", key).Scan(&failures, &lastFailure)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	failures = max(failures-1, 0)
+	var lockedUntil sql.NullTime
+	if wait := lockFor(failures); wait > 0 {
+		lockedUntil = sql.NullTime{Time: lastFailure.Add(wait), Valid: true}
+	}
+	if _, err = tx.ExecContext(ctx, "update auth_attempts set failures=$2, locked_until=$3 where key=$1;", key, failures, lockedUntil); err != nil {
+		return ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+func (dbs *DBStorage) ClearAttempts(ctx context.Context, key string) error {
+	_, err := dbs.db.ExecContext(ctx, "delete from auth_attempts where key=$1;", key)
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+)
+
+// nullJSON stores an empty raw message as SQL NULL.
+func nullJSON(raw []byte) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return raw
+}
+
+// AppendAudit chains e to the latest entry and stores it. The advisory lock serialises writers
+// so that every entry references the hash of its predecessor.
+func (dbs *DBStorage) AppendAudit(ctx context.Context, e audit.Entry) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "select pg_advisory_xact_lock(hashtext('audit_log'));"); err != nil {
+		return ErrInternal
+	}
+	err = tx.QueryRowContext(ctx, "select hash from audit_log order by id desc limit 1;").Scan(&e.PrevHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		e.PrevHash = audit.GenesisHash
+	} else if err != nil {
+		return ErrInternal
+	}
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	e.Hash = audit.Compute(e)
+	_, err = tx.ExecContext(ctx, `insert into audit_log (actor_id, action, target, ip, user_agent, request_id, before, after, created_at, prev_hash, hash)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`,
+		e.ActorID, e.Action, e.Target, e.IP, e.UserAgent, e.RequestID, nullJSON(e.Before), nullJSON(e.After), e.CreatedAt, e.PrevHash, e.Hash)
+	if err != nil {
+		return ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+const auditColumns = "id, actor_id, action, target, ip, user_agent, request_id, before, after, created_at, prev_hash, hash, redacted_at"
+
+func scanAudit(rows *sql.Rows) (audit.Entry, error) {
+	var e audit.Entry
+	var actor sql.NullInt64
+	var before, after []byte
+	var redacted sql.NullTime
+	err := rows.Scan(&e.ID, &actor, &e.Action, &e.Target, &e.IP, &e.UserAgent, &e.RequestID, &before, &after, &e.CreatedAt, &e.PrevHash, &e.Hash, &redacted)
+	if redacted.Valid {
+		e.RedactedAt = &redacted.Time
+	}
+	if actor.Valid {
+		id := int(actor.Int64)
+		e.ActorID = &id
+	}
+	e.Before, e.After = before, after
+	return e, err
+}
+
+// QueryAudit returns entries matching f, newest first.
+func (dbs *DBStorage) QueryAudit(ctx context.Context, f audit.Filter) ([]audit.Entry, error) {
+	rows, err := dbs.db.QueryContext(ctx, `select `+auditColumns+` from audit_log
+		where ($1 = 0 or actor_id = $1) and ($2 = '' or action = $2) and ($3 = '' or target = $3)
+		and ($4::timestamptz is null or created_at >= $4) and ($5::timestamptz is null or created_at < $5)
+		and ($6 = 0 or id < $6)
+		order by id desc limit $7;`,
+		f.ActorID, f.Action, f.Target, nullTime(f.From), nullTime(f.To), f.BeforeID, f.Limit)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []audit.Entry
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, e)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	if resp == nil {
+		return nil, ErrNoData
+	}
+	return resp, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// VerifyAudit walks the whole chain and returns the number of entries checked and
+// the ID of the first entry whose hash or link does not match, or 0 if the chain is intact.
+// Pseudonymised entries no longer hash to their stored value, so only their link is checked;
+// the successor's link still pins that stored hash.
+func (dbs *DBStorage) VerifyAudit(ctx context.Context) (int, int64, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select "+auditColumns+" from audit_log order by id;")
+	if err != nil {
+		return 0, 0, ErrInternal
+	}
+	defer rows.Close()
+	prev := audit.GenesisHash
+	checked := 0
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return checked, 0, ErrInternal
+		}
+		checked++
+		if e.PrevHash != prev || (e.RedactedAt == nil && audit.Compute(e) != e.Hash) {
+			return checked, e.ID, nil
+		}
+		prev = e.Hash
+	}
+	if rows.Err() != nil {
+		return checked, 0, ErrInternal
+	}
+	return checked, 0, nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+// Events leave the ledger through three tables, all written in the transaction of the change:
+//   - outbox is the authoritative stream of domain events for integrations; the outbox relay
+//     publishes it at least once, in id order, and every other consumer can be rebuilt from it.
+//   - order_events is the per-user feed behind the event stream and history endpoints. Events
+//     are written under the user's lock, so a user's event ids follow commit order and readers
+//     can resume after the last id they saw.
+//   - webhook_deliveries is the retry queue of user webhooks, fanned out from order_events.
+//
+// New integrations should consume the outbox rather than add another table.
+
+// EventsChannel is the Postgres NOTIFY channel announcing new order events.
+const EventsChannel = "order_events"
+
+// recordEvent appends an event to the user's log within tx and queues it for the user's webhooks;
+// the notification is delivered on commit. It takes the user's lock if tx does not hold it yet, so
+// no other transaction of the user can commit a lower event id after this one.
+func recordEvent(ctx context.Context, tx *sql.Tx, userID int, eventType string, orderID string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err = lockUsers(ctx, tx, userID); err != nil {
+		return err
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, "insert into order_events (user_id, event_type, order_id, payload) values ($1, $2, $3, $4) returning id;",
+		userID, eventType, orderID, data).Scan(&id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `insert into webhook_deliveries (endpoint_id, event_id, event_type, payload)
+		select id, $1, $2, $3 from webhook_endpoints where user_id=$4 and active and $2 = any(event_types);`, id, eventType, data, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "select pg_notify($1, json_build_object('id', $2::bigint, 'user_id', $3::int)::text);", EventsChannel, id, userID)
+	return err
+}
+
+func (dbs *DBStorage) GetEventsSince(ctx context.Context, userID int, afterID int64, limit int) ([]datamodels.Event, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select id, event_type, order_id, payload, created_at from order_events where user_id=$1 and id>$2 order by id limit $3;", userID, afterID, limit)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.Event
+	for rows.Next() {
+		var tmp datamodels.Event
+		err = rows.Scan(&tmp.ID, &tmp.Type, &tmp.Order, &tmp.Payload, &tmp.CreatedAt)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, tmp)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	return resp, nil
+}
+
+func (dbs *DBStorage) LastEventID(ctx context.Context, userID int) (int64, error) {
+	var id sql.NullInt64
+	err := dbs.db.QueryRowContext(ctx, "select max(id) from order_events where user_id=$1;", userID).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInternal
+	}
+	return id.Int64, nil
+}
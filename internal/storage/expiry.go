@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/domain"
+)
+
+// balance row kinds
+const (
+	kindOrder      = "order"
+	kindWithdrawal = "withdrawal"
+	kindExpiration = "expiration"
+	kindTransfer   = "transfer"
+	kindRefund     = "refund"
+)
+
+// ExpiryPolicy sets how long credited points live; LifetimeMonths 0 keeps them forever.
+// Balances list the points expiring within Notice.
+type ExpiryPolicy struct {
+	LifetimeMonths int
+	Notice         time.Duration
+}
+
+// SetExpiryPolicy applies p to orders credited from now on.
+func (dbs *DBStorage) SetExpiryPolicy(p ExpiryPolicy) {
+	dbs.expiry = p
+}
+
+// consumeCredits spends cents from the user's unspent credits, oldest first, and returns the
+// earliest expiry of the spent points. Callers hold the user lock.
+func consumeCredits(ctx context.Context, tx *sql.Tx, userID int, cents int) (sql.NullTime, error) {
+	var earliest sql.NullTime
+	rows, err := tx.QueryContext(ctx, "select id, remaining, expires_at from balance where user_id=$1 and remaining>0 order by created_at, id for update;", userID)
+	if err != nil {
+		return earliest, err
+	}
+	type credit struct {
+		id, remaining int
+		expiresAt     sql.NullTime
+	}
+	var credits []credit
+	for rows.Next() {
+		var c credit
+		if err = rows.Scan(&c.id, &c.remaining, &c.expiresAt); err != nil {
+			rows.Close()
+			return earliest, err
+		}
+		credits = append(credits, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return earliest, err
+	}
+	for _, c := range credits {
+		if cents <= 0 {
+			break
+		}
+		used := min(c.remaining, cents)
+		if _, err = tx.ExecContext(ctx, "update balance set remaining=remaining-$1 where id=$2;", used, c.id); err != nil {
+			return earliest, err
+		}
+		if c.expiresAt.Valid && (!earliest.Valid || c.expiresAt.Time.Before(earliest.Time)) {
+			earliest = c.expiresAt
+		}
+		cents -= used
+	}
+	return earliest, nil
+}
+
+// ExpirePoints writes off the unspent rest of the expired credits of up to limit users and
+// returns the number of points expired. It never expires points that active holds reserve,
+// so the available balance stays non-negative; the reserved rest expires once the hold ends.
+// Users whose whole balance is held are skipped, so a result of zero means nothing is left
+// to expire rather than a batch of such users.
+func (dbs *DBStorage) ExpirePoints(ctx context.Context, limit int) (float64, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	defer tx.Rollback()
+	// the having clause is the available balance of availableCents
+	rows, err := tx.QueryContext(ctx, `select b.user_id from balance b
+		where b.remaining>0 and b.expires_at <= now() and b.user_id is not null
+		group by b.user_id
+		having (select coalesce(sum(accrual), 0) from balance where user_id=b.user_id and order_status='PROCESSED')
+			+ (select coalesce(sum(amount), 0) from balance_adjustments where user_id=b.user_id)
+			- (select coalesce(sum(amount), 0) from holds where user_id=b.user_id and status='HELD' and expires_at > now()) > 0
+		order by b.user_id limit $1;`, limit)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	var users []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, ErrInternal
+		}
+		users = append(users, id)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, ErrInternal
+	}
+	if len(users) == 0 {
+		return 0, nil
+	}
+	if err = lockUsers(ctx, tx, users...); err != nil {
+		return 0, ErrInternal
+	}
+	var expired float64
+	for _, userID := range users {
+		points, err := expireUserPoints(ctx, tx, userID)
+		if err != nil {
+			return 0, err
+		}
+		expired += points
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, ErrInternal
+	}
+	return expired, nil
+}
+
+// expireUserPoints expires the locked user's expired credits, at most their available balance.
+func expireUserPoints(ctx context.Context, tx *sql.Tx, userID int) (float64, error) {
+	available, err := availableCents(ctx, tx, userID)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	rows, err := tx.QueryContext(ctx, `select id, coalesce(order_id, ''), remaining from balance
+		where user_id=$1 and remaining>0 and expires_at <= now()
+		order by expires_at, id for update;`, userID)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	type credit struct {
+		id, remaining int
+		order         string
+	}
+	var credits []credit
+	for rows.Next() {
+		var c credit
+		if err = rows.Scan(&c.id, &c.order, &c.remaining); err != nil {
+			rows.Close()
+			return 0, ErrInternal
+		}
+		credits = append(credits, c)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, ErrInternal
+	}
+	var expired float64
+	for _, c := range credits {
+		cents := min(c.remaining, available)
+		if cents <= 0 {
+			break
+		}
+		_, err = tx.ExecContext(ctx, "insert into balance (user_id, accrual, order_status, kind) values ($1, $2, 'PROCESSED', $3);", userID, -cents, kindExpiration)
+		if err != nil {
+			return 0, ErrInternal
+		}
+		if _, err = tx.ExecContext(ctx, "update balance set remaining=remaining-$1 where id=$2;", cents, c.id); err != nil {
+			return 0, ErrInternal
+		}
+		points := float64(cents) / 100
+		err = appendOutbox(ctx, tx, domain.PointsExpired, userID, c.order, domain.PointsExpiredPayload{Order: c.order, Points: points})
+		if err != nil {
+			return 0, ErrInternal
+		}
+		available -= cents
+		expired += points
+	}
+	return expired, nil
+}
+
+// expiringSoon sums the user's unspent points by the day they expire within the notice window.
+func (dbs *DBStorage) expiringSoon(ctx context.Context, userID int) ([]datamodels.ExpiringPoints, error) {
+	if dbs.expiry.Notice <= 0 {
+		return nil, nil
+	}
+	rows, err := dbs.db.QueryContext(ctx, `select date_trunc('day', expires_at), sum(remaining) from balance
+		where user_id=$1 and remaining>0 and expires_at < now() + make_interval(secs => $2)
+		group by 1 order by 1;`, userID, dbs.expiry.Notice.Seconds())
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.ExpiringPoints
+	for rows.Next() {
+		var v datamodels.ExpiringPoints
+		var cents int
+		if err = rows.Scan(&v.ExpiresAt, &cents); err != nil {
+			return nil, ErrInternal
+		}
+		v.Sum = float64(cents) / 100
+		resp = append(resp, v)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	return resp, nil
+}
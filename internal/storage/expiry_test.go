@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+func expire(t *testing.T, dbs *DBStorage, order string) {
+	t.Helper()
+	exec(t, dbs, "update balance set expires_at = now() - interval '1 day' where order_id=$1 and kind='order';", order)
+}
+
+func expireAll(t *testing.T, dbs *DBStorage) {
+	t.Helper()
+	for {
+		n, err := dbs.ExpirePoints(context.Background(), 10000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func TestExpirePointsKeepsHeldPoints(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+
+	captured, _ := newUser(t, dbs)
+	expire(t, dbs, credit(t, dbs, captured, 10))
+	hold, err := dbs.CreateHold(ctx, datamodels.OrderInfo{UserID: captured, OrderID: newOrder(t), Sum: 3}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireAll(t, dbs)
+	wantBalance(t, dbs, captured, 300)
+	checkLedger(t, dbs, captured)
+	if _, err = dbs.CaptureHold(ctx, captured, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	expireAll(t, dbs)
+	wantBalance(t, dbs, captured, 0)
+	checkLedger(t, dbs, captured)
+
+	cancelled, _ := newUser(t, dbs)
+	expire(t, dbs, credit(t, dbs, cancelled, 10))
+	hold, err = dbs.CreateHold(ctx, datamodels.OrderInfo{UserID: cancelled, OrderID: newOrder(t), Sum: 3}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireAll(t, dbs)
+	wantBalance(t, dbs, cancelled, 300)
+	if _, err = dbs.CancelHold(ctx, cancelled, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	expireAll(t, dbs)
+	wantBalance(t, dbs, cancelled, 0)
+	checkLedger(t, dbs, cancelled)
+}
+
+func TestExpirePointsConcurrentWithWithdrawal(t *testing.T) {
+	dbs := testStorage(t)
+	for i := 0; i < 10; i++ {
+		id, _ := newUser(t, dbs)
+		expire(t, dbs, credit(t, dbs, id, 10))
+		credit(t, dbs, id, 10)
+		order := newOrder(t)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := dbs.ExpirePoints(context.Background(), 10000); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			err := dbs.Withdraw(context.Background(), datamodels.OrderInfo{UserID: id, OrderID: order, Sum: 15})
+			if err != nil && !errors.Is(err, ErrNotEnoughMoney) {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+		expireAll(t, dbs)
+		// either the withdrawal spent the expired credit first or it found only the fresh one left
+		if got, _ := balanceCents(t, dbs, id); got != 500 && got != 1000 {
+			t.Errorf("user %d: balance %d, want 500 or 1000", id, got)
+		}
+		checkLedger(t, dbs, id)
+	}
+}
+
+func TestExpirePointsSkipsHeldBalances(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+	held, _ := newUser(t, dbs)
+	expire(t, dbs, credit(t, dbs, held, 10))
+	hold, err := dbs.CreateHold(ctx, datamodels.OrderInfo{UserID: held, OrderID: newOrder(t), Sum: 10}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	free, _ := newUser(t, dbs)
+	expire(t, dbs, credit(t, dbs, free, 10))
+	// batches of one user: the fully held user must not end the run before the next one
+	for {
+		n, err := dbs.ExpirePoints(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	wantBalance(t, dbs, held, 1000)
+	wantBalance(t, dbs, free, 0)
+	if _, err = dbs.CancelHold(ctx, held, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	checkLedger(t, dbs, held)
+	checkLedger(t, dbs, free)
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/luhn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrHoldNotFound = errors.New("hold not found")
+	ErrHoldState    = errors.New("hold is no longer active")
+)
+
+const holdColumns = "id, order_id, amount, status, created_at, expires_at, resolved_at"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHold(row rowScanner) (datamodels.Hold, error) {
+	var h datamodels.Hold
+	var cents int
+	var resolved sql.NullTime
+	if err := row.Scan(&h.ID, &h.Order, &cents, &h.Status, &h.CreatedAt, &h.ExpiresAt, &resolved); err != nil {
+		return datamodels.Hold{}, err
+	}
+	h.Sum = float64(cents) / 100
+	if resolved.Valid {
+		h.ResolvedAt = &resolved.Time
+	}
+	return h, nil
+}
+
+// CreateHold reserves the order's sum of the user's points for the withdrawal for ttl.
+func (dbs *DBStorage) CreateHold(ctx context.Context, order datamodels.OrderInfo, ttl time.Duration) (datamodels.Hold, error) {
+	userID, orderID := order.UserID, order.OrderID
+	if !luhn.Valid(orderID) {
+		return datamodels.Hold{}, ErrInvalidOrder
+	}
+	cents := int(math.Round(order.Sum * 100))
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	defer tx.Rollback()
+	if err = lockUsers(ctx, tx, userID); err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	var used bool
+	if err = tx.QueryRowContext(ctx, "select exists (select 1 from balance where order_id=$1);", orderID).Scan(&used); err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	if used {
+		return datamodels.Hold{}, ErrInvalidOrder
+	}
+	available, err := availableCents(ctx, tx, userID)
+	if err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	if available < cents {
+		return datamodels.Hold{}, ErrNotEnoughMoney
+	}
+	partnerID, err := lookupPartner(ctx, tx, order.Partner)
+	if err != nil {
+		return datamodels.Hold{}, err
+	}
+	hold, err := scanHold(tx.QueryRowContext(ctx, "insert into holds (user_id, order_id, amount, expires_at, partner_id) values ($1, $2, $3, now() + make_interval(secs => $4), $5) returning "+holdColumns+";",
+		userID, orderID, cents, ttl.Seconds(), partnerID))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return datamodels.Hold{}, ErrInvalidOrder
+	}
+	if err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	return hold, nil
+}
+
+// CaptureHold turns an active hold into a withdrawal of the held points; it fails with
+// ErrNotEnoughMoney, keeping the hold, if the balance no longer covers them.
+func (dbs *DBStorage) CaptureHold(ctx context.Context, userID int, id int) (datamodels.Hold, error) {
+	return dbs.resolveHold(ctx, userID, id, datamodels.HoldCaptured)
+}
+
+// CancelHold releases the points of an active hold.
+func (dbs *DBStorage) CancelHold(ctx context.Context, userID int, id int) (datamodels.Hold, error) {
+	return dbs.resolveHold(ctx, userID, id, datamodels.HoldCancelled)
+}
+
+func (dbs *DBStorage) resolveHold(ctx context.Context, userID int, id int, status string) (datamodels.Hold, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	defer tx.Rollback()
+	if err = lockUsers(ctx, tx, userID); err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	var active bool
+	var partnerID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "select status='HELD' and expires_at > now(), partner_id from holds where id=$1 and user_id=$2 for update;", id, userID).Scan(&active, &partnerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return datamodels.Hold{}, ErrHoldNotFound
+	}
+	if err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	if !active {
+		return datamodels.Hold{}, ErrHoldState
+	}
+	hold, err := scanHold(tx.QueryRowContext(ctx, "update holds set status=$2, resolved_at=now() where id=$1 returning "+holdColumns+";", id, status))
+	if err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	if status == datamodels.HoldCaptured {
+		// The hold no longer counts against the balance here, so this checks that the held points
+		// are still there; a shortfall rolls back and leaves the hold active.
+		cents := int(math.Round(hold.Sum * 100))
+		available, err := availableCents(ctx, tx, userID)
+		if err != nil {
+			return datamodels.Hold{}, ErrInternal
+		}
+		if available < cents {
+			return datamodels.Hold{}, ErrNotEnoughMoney
+		}
+		if err = postWithdrawal(ctx, tx, userID, hold.Order, cents, partnerID); err != nil {
+			return datamodels.Hold{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return datamodels.Hold{}, ErrInternal
+	}
+	return hold, nil
+}
+
+// GetHolds lists the holds of the user, newest first.
+func (dbs *DBStorage) GetHolds(ctx context.Context, userID int) ([]datamodels.Hold, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select "+holdColumns+" from holds where user_id=$1 order by created_at desc, id desc;", userID)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.Hold
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, hold)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	if resp == nil {
+		return nil, ErrNoData
+	}
+	return resp, nil
+}
+
+// ExpireHolds marks stale holds expired and returns how many were released.
+func (dbs *DBStorage) ExpireHolds(ctx context.Context) (int, error) {
+	res, err := dbs.db.ExecContext(ctx, "update holds set status='EXPIRED', resolved_at=now() where status='HELD' and expires_at <= now();")
+	if err != nil {
+		return 0, ErrInternal
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+func TestCaptureRechecksBalance(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+	id, _ := newUser(t, dbs)
+	credit(t, dbs, id, 10)
+	hold, err := dbs.CreateHold(ctx, datamodels.OrderInfo{UserID: id, OrderID: newOrder(t), Sum: 6}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a debit that did not respect the hold
+	exec(t, dbs, "insert into balance (user_id, accrual, order_status, kind) values ($1, -500, 'PROCESSED', $2);", id, kindExpiration)
+	if _, err = dbs.CaptureHold(ctx, id, hold.ID); !errors.Is(err, ErrNotEnoughMoney) {
+		t.Fatalf("capture: %v, want ErrNotEnoughMoney", err)
+	}
+	holds, err := dbs.GetHolds(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 1 || holds[0].Status != datamodels.HoldHeld {
+		t.Fatalf("holds after failed capture: %+v", holds)
+	}
+	if _, err = dbs.CancelHold(ctx, id, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	wantBalance(t, dbs, id, 500)
+}
+
+func TestCreateHoldConcurrently(t *testing.T) {
+	dbs := testStorage(t)
+	id, _ := newUser(t, dbs)
+	credit(t, dbs, id, 10)
+	orders := make([]string, 10)
+	for i := range orders {
+		orders[i] = newOrder(t)
+	}
+	var done atomic.Int32
+	var wg sync.WaitGroup
+	for _, order := range orders {
+		wg.Add(1)
+		go func(order string) {
+			defer wg.Done()
+			_, err := dbs.CreateHold(context.Background(), datamodels.OrderInfo{UserID: id, OrderID: order, Sum: 3}, time.Minute)
+			switch {
+			case err == nil:
+				done.Add(1)
+			case !errors.Is(err, ErrNotEnoughMoney):
+				t.Error(err)
+			}
+		}(order)
+	}
+	wg.Wait()
+	if done.Load() != 3 {
+		t.Errorf("%d holds of 3 granted on a balance of 10, want 3", done.Load())
+	}
+	if _, available := balanceCents(t, dbs, id); available != 100 {
+		t.Errorf("available %d, want 100", available)
+	}
+	checkLedger(t, dbs, id)
+}
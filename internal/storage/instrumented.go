@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/audit"
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/domain"
+	"github.com/N0rkton/gophermart/internal/metrics"
+	"github.com/N0rkton/gophermart/internal/tracing"
+)
+
+var tracer = tracing.Tracer("github.com/N0rkton/gophermart/internal/storage")
+
+// instrumentedStorage records a span, call latency and points movement for every Storage method.
+type instrumentedStorage struct {
+	next Storage
+}
+
+func NewInstrumented(next Storage) Storage {
+	return &instrumentedStorage{next: next}
+}
+
+// observe starts the span of a storage call; the returned function finishes it.
+func observe(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "storage."+method)
+	return ctx, func(err error) {
+		metrics.ObserveDB(method, start, err)
+		tracing.End(span, err)
+	}
+}
+
+func (s *instrumentedStorage) Register(ctx context.Context, login string, password string) error {
+	ctx, done := observe(ctx, "Register")
+	err := s.next.Register(ctx, login, password)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) Login(ctx context.Context, login string, password string) (int, error) {
+	ctx, done := observe(ctx, "Login")
+	id, err := s.next.Login(ctx, login, password)
+	done(err)
+	return id, err
+}
+func (s *instrumentedStorage) OrdersPost(ctx context.Context, order datamodels.OrderInfo) error {
+	ctx, done := observe(ctx, "OrdersPost")
+	err := s.next.OrdersPost(ctx, order)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) GetOrderList(ctx context.Context, order datamodels.OrderInfo) ([]datamodels.Order, error) {
+	ctx, done := observe(ctx, "GetOrderList")
+	resp, err := s.next.GetOrderList(ctx, order)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) Balance(ctx context.Context, order datamodels.OrderInfo) (datamodels.Balance, error) {
+	ctx, done := observe(ctx, "Balance")
+	resp, err := s.next.Balance(ctx, order)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) Withdraw(ctx context.Context, order datamodels.OrderInfo) error {
+	ctx, done := observe(ctx, "Withdraw")
+	err := s.next.Withdraw(ctx, order)
+	done(err)
+	if err == nil {
+		metrics.AddWithdrawn(order.Sum)
+	}
+	return err
+}
+func (s *instrumentedStorage) GetWithdrawList(ctx context.Context, order datamodels.OrderInfo) ([]datamodels.Withdrawals, error) {
+	ctx, done := observe(ctx, "GetWithdrawList")
+	resp, err := s.next.GetWithdrawList(ctx, order)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) GetAllOrdersForAccrual(ctx context.Context) ([]string, error) {
+	ctx, done := observe(ctx, "GetAllOrdersForAccrual")
+	resp, err := s.next.GetAllOrdersForAccrual(ctx)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) UpdateAccrual(ctx context.Context, accrual datamodels.Accrual) (float64, error) {
+	ctx, done := observe(ctx, "UpdateAccrual")
+	credited, err := s.next.UpdateAccrual(ctx, accrual)
+	done(err)
+	// the counter only grows; corrections that lower an accrual are not subtracted
+	if credited > 0 {
+		metrics.AddAccrued(credited)
+	}
+	return credited, err
+}
+func (s *instrumentedStorage) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	ctx, done := observe(ctx, "CountOrdersByStatus")
+	resp, err := s.next.CountOrdersByStatus(ctx)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) OrdersPostBatch(ctx context.Context, userID int, orders []string) ([]datamodels.BatchOrderResult, error) {
+	ctx, done := observe(ctx, "OrdersPostBatch")
+	resp, err := s.next.OrdersPostBatch(ctx, userID, orders)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) GetEventsSince(ctx context.Context, userID int, afterID int64, limit int) ([]datamodels.Event, error) {
+	ctx, done := observe(ctx, "GetEventsSince")
+	resp, err := s.next.GetEventsSince(ctx, userID, afterID, limit)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) LastEventID(ctx context.Context, userID int) (int64, error) {
+	ctx, done := observe(ctx, "LastEventID")
+	id, err := s.next.LastEventID(ctx, userID)
+	done(err)
+	return id, err
+}
+func (s *instrumentedStorage) CreateWebhook(ctx context.Context, endpoint datamodels.WebhookEndpoint) (datamodels.WebhookEndpoint, error) {
+	ctx, done := observe(ctx, "CreateWebhook")
+	resp, err := s.next.CreateWebhook(ctx, endpoint)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) GetWebhooks(ctx context.Context, userID int) ([]datamodels.WebhookEndpoint, error) {
+	ctx, done := observe(ctx, "GetWebhooks")
+	resp, err := s.next.GetWebhooks(ctx, userID)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) DeleteWebhook(ctx context.Context, userID int, id int) error {
+	ctx, done := observe(ctx, "DeleteWebhook")
+	err := s.next.DeleteWebhook(ctx, userID, id)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) GetWebhookDeliveries(ctx context.Context, userID int, status string, limit int) ([]datamodels.WebhookDelivery, error) {
+	ctx, done := observe(ctx, "GetWebhookDeliveries")
+	resp, err := s.next.GetWebhookDeliveries(ctx, userID, status, limit)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) ReplayWebhookDelivery(ctx context.Context, userID int, id int64) error {
+	ctx, done := observe(ctx, "ReplayWebhookDelivery")
+	err := s.next.ReplayWebhookDelivery(ctx, userID, id)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]datamodels.WebhookDelivery, error) {
+	ctx, done := observe(ctx, "ClaimWebhookDeliveries")
+	resp, err := s.next.ClaimWebhookDeliveries(ctx, limit, lease)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) CompleteWebhookDelivery(ctx context.Context, id int64) error {
+	ctx, done := observe(ctx, "CompleteWebhookDelivery")
+	err := s.next.CompleteWebhookDelivery(ctx, id)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) FailWebhookDelivery(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	ctx, done := observe(ctx, "FailWebhookDelivery")
+	err := s.next.FailWebhookDelivery(ctx, id, reason, retryAt)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) PublishOutbox(ctx context.Context, limit int, lease time.Duration, publish func([]domain.Event) error) (int, error) {
+	ctx, done := observe(ctx, "PublishOutbox")
+	n, err := s.next.PublishOutbox(ctx, limit, lease, publish)
+	done(err)
+	return n, err
+}
+func (s *instrumentedStorage) GetUser(ctx context.Context, id int) (datamodels.User, error) {
+	ctx, done := observe(ctx, "GetUser")
+	resp, err := s.next.GetUser(ctx, id)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) FindUsers(ctx context.Context, login string, limit int) ([]datamodels.User, error) {
+	ctx, done := observe(ctx, "FindUsers")
+	resp, err := s.next.FindUsers(ctx, login, limit)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) SetUserRole(ctx context.Context, login string, role string) error {
+	ctx, done := observe(ctx, "SetUserRole")
+	err := s.next.SetUserRole(ctx, login, role)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) SetUserBlocked(ctx context.Context, id int, blocked bool) error {
+	ctx, done := observe(ctx, "SetUserBlocked")
+	err := s.next.SetUserBlocked(ctx, id, blocked)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) RequeueOrder(ctx context.Context, orderID string) error {
+	ctx, done := observe(ctx, "RequeueOrder")
+	err := s.next.RequeueOrder(ctx, orderID)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) InvalidateOrder(ctx context.Context, orderID string) error {
+	ctx, done := observe(ctx, "InvalidateOrder")
+	err := s.next.InvalidateOrder(ctx, orderID)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) AdjustBalance(ctx context.Context, userID int, adminID int, adj datamodels.Adjustment) error {
+	ctx, done := observe(ctx, "AdjustBalance")
+	err := s.next.AdjustBalance(ctx, userID, adminID, adj)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) AppendAudit(ctx context.Context, e audit.Entry) error {
+	ctx, done := observe(ctx, "AppendAudit")
+	err := s.next.AppendAudit(ctx, e)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) QueryAudit(ctx context.Context, f audit.Filter) ([]audit.Entry, error) {
+	ctx, done := observe(ctx, "QueryAudit")
+	resp, err := s.next.QueryAudit(ctx, f)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) VerifyAudit(ctx context.Context) (int, int64, error) {
+	ctx, done := observe(ctx, "VerifyAudit")
+	checked, broken, err := s.next.VerifyAudit(ctx)
+	done(err)
+	return checked, broken, err
+}
+func (s *instrumentedStorage) AttemptsLockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	ctx, done := observe(ctx, "AttemptsLockedUntil")
+	resp, err := s.next.AttemptsLockedUntil(ctx, keys)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) RecordAttempt(ctx context.Context, key string, window time.Duration, lockFor func(attempts int) time.Duration) (time.Time, error) {
+	ctx, done := observe(ctx, "RecordAttempt")
+	resp, err := s.next.RecordAttempt(ctx, key, window, lockFor)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) ForgiveAttempt(ctx context.Context, key string, lockFor func(attempts int) time.Duration) error {
+	ctx, done := observe(ctx, "ForgiveAttempt")
+	err := s.next.ForgiveAttempt(ctx, key, lockFor)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) ClearAttempts(ctx context.Context, key string) error {
+	ctx, done := observe(ctx, "ClearAttempts")
+	err := s.next.ClearAttempts(ctx, key)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	ctx, done := observe(ctx, "TakeRateToken")
+	tokens, allowed, err := s.next.TakeRateToken(ctx, key, rate, burst)
+	done(err)
+	return tokens, allowed, err
+}
+func (s *instrumentedStorage) GetTwoFactor(ctx context.Context, userID int) (datamodels.TwoFactor, error) {
+	ctx, done := observe(ctx, "GetTwoFactor")
+	resp, err := s.next.GetTwoFactor(ctx, userID)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, done := observe(ctx, "SetTOTPSecret")
+	err := s.next.SetTOTPSecret(ctx, userID, secret)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) EnableTwoFactor(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	ctx, done := observe(ctx, "EnableTwoFactor")
+	err := s.next.EnableTwoFactor(ctx, userID, step, codeHashes)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) DisableTwoFactor(ctx context.Context, userID int) error {
+	ctx, done := observe(ctx, "DisableTwoFactor")
+	err := s.next.DisableTwoFactor(ctx, userID)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	ctx, done := observe(ctx, "UseTOTPStep")
+	err := s.next.UseTOTPStep(ctx, userID, step)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	ctx, done := observe(ctx, "UseRecoveryCode")
+	err := s.next.UseRecoveryCode(ctx, userID, codeHash)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) GetUserByLogin(ctx context.Context, login string) (datamodels.User, error) {
+	ctx, done := observe(ctx, "GetUserByLogin")
+	resp, err := s.next.GetUserByLogin(ctx, login)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) SetPassword(ctx context.Context, userID int, password string) error {
+	ctx, done := observe(ctx, "SetPassword")
+	err := s.next.SetPassword(ctx, userID, password)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, done := observe(ctx, "CreatePasswordReset")
+	err := s.next.CreatePasswordReset(ctx, userID, tokenHash, expiresAt)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) ResetPassword(ctx context.Context, tokenHash string, password string) (int, error) {
+	ctx, done := observe(ctx, "ResetPassword")
+	resp, err := s.next.ResetPassword(ctx, tokenHash, password)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) DeleteAccount(ctx context.Context, userID int) error {
+	ctx, done := observe(ctx, "DeleteAccount")
+	err := s.next.DeleteAccount(ctx, userID)
+	done(err)
+	return err
+}
+func (s *instrumentedStorage) PurgeDeletedUsers(ctx context.Context, grace time.Duration) (int, error) {
+	ctx, done := observe(ctx, "PurgeDeletedUsers")
+	resp, err := s.next.PurgeDeletedUsers(ctx, grace)
+	done(err)
+	return resp, err
+}
+
+// instrumentedUsers records a span and call latency for every UserRepository method.
+type instrumentedUsers struct {
+	next UserRepository
+}
+
+func NewInstrumentedUsers(next UserRepository) UserRepository {
+	return &instrumentedUsers{next: next}
+}
+func (s *instrumentedUsers) GetProfile(ctx context.Context, userID int) (datamodels.Profile, error) {
+	ctx, done := observe(ctx, "GetProfile")
+	resp, err := s.next.GetProfile(ctx, userID)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedUsers) UpdateProfile(ctx context.Context, userID int, patch datamodels.ProfilePatch) (datamodels.Profile, error) {
+	ctx, done := observe(ctx, "UpdateProfile")
+	resp, err := s.next.UpdateProfile(ctx, userID, patch)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedUsers) CreateEmailVerification(ctx context.Context, userID int, email string, tokenHash string, expiresAt time.Time) error {
+	ctx, done := observe(ctx, "CreateEmailVerification")
+	err := s.next.CreateEmailVerification(ctx, userID, email, tokenHash, expiresAt)
+	done(err)
+	return err
+}
+func (s *instrumentedUsers) VerifyEmail(ctx context.Context, tokenHash string) (int, error) {
+	ctx, done := observe(ctx, "VerifyEmail")
+	resp, err := s.next.VerifyEmail(ctx, tokenHash)
+	done(err)
+	return resp, err
+}
+
+// instrumentedAPIKeys records a span and call latency for every APIKeyRepository method.
+type instrumentedAPIKeys struct {
+	next APIKeyRepository
+}
+
+func NewInstrumentedAPIKeys(next APIKeyRepository) APIKeyRepository {
+	return &instrumentedAPIKeys{next: next}
+}
+func (s *instrumentedAPIKeys) CreateAPIKey(ctx context.Context, key datamodels.APIKey, keyHash string) (datamodels.APIKey, error) {
+	ctx, done := observe(ctx, "CreateAPIKey")
+	resp, err := s.next.CreateAPIKey(ctx, key, keyHash)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedAPIKeys) GetAPIKeys(ctx context.Context, userID int) ([]datamodels.APIKey, error) {
+	ctx, done := observe(ctx, "GetAPIKeys")
+	resp, err := s.next.GetAPIKeys(ctx, userID)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedAPIKeys) DeleteAPIKey(ctx context.Context, userID int, id int) error {
+	ctx, done := observe(ctx, "DeleteAPIKey")
+	err := s.next.DeleteAPIKey(ctx, userID, id)
+	done(err)
+	return err
+}
+func (s *instrumentedAPIKeys) AuthenticateAPIKey(ctx context.Context, prefix string, keyHash string) (datamodels.APIKey, error) {
+	ctx, done := observe(ctx, "AuthenticateAPIKey")
+	resp, err := s.next.AuthenticateAPIKey(ctx, prefix, keyHash)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) ExpirePoints(ctx context.Context, limit int) (float64, error) {
+	ctx, done := observe(ctx, "ExpirePoints")
+	resp, err := s.next.ExpirePoints(ctx, limit)
+	done(err)
+	if err == nil {
+		metrics.AddExpired(resp)
+	}
+	return resp, err
+}
+func (s *instrumentedStorage) GetTier(ctx context.Context, userID int) (datamodels.TierStatus, error) {
+	ctx, done := observe(ctx, "GetTier")
+	resp, err := s.next.GetTier(ctx, userID)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) RecalculateTiers(ctx context.Context) (int, error) {
+	ctx, done := observe(ctx, "RecalculateTiers")
+	resp, err := s.next.RecalculateTiers(ctx)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) Transfer(ctx context.Context, fromID int, toLogin string, sum float64, dailyLimit float64) (datamodels.Transfer, error) {
+	ctx, done := observe(ctx, "Transfer")
+	resp, err := s.next.Transfer(ctx, fromID, toLogin, sum, dailyLimit)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) GetTransfers(ctx context.Context, userID int) ([]datamodels.Transfer, error) {
+	ctx, done := observe(ctx, "GetTransfers")
+	resp, err := s.next.GetTransfers(ctx, userID)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) CreateHold(ctx context.Context, order datamodels.OrderInfo, ttl time.Duration) (datamodels.Hold, error) {
+	ctx, done := observe(ctx, "CreateHold")
+	resp, err := s.next.CreateHold(ctx, order, ttl)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) CancelHold(ctx context.Context, userID int, id int) (datamodels.Hold, error) {
+	ctx, done := observe(ctx, "CancelHold")
+	resp, err := s.next.CancelHold(ctx, userID, id)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) GetHolds(ctx context.Context, userID int) ([]datamodels.Hold, error) {
+	ctx, done := observe(ctx, "GetHolds")
+	resp, err := s.next.GetHolds(ctx, userID)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) ExpireHolds(ctx context.Context) (int, error) {
+	ctx, done := observe(ctx, "ExpireHolds")
+	resp, err := s.next.ExpireHolds(ctx)
+	done(err)
+	return resp, err
+}
+func (s *instrumentedStorage) CaptureHold(ctx context.Context, userID int, id int) (datamodels.Hold, error) {
+	ctx, done := observe(ctx, "CaptureHold")
+	resp, err := s.next.CaptureHold(ctx, userID, id)
+	done(err)
+	if err == nil {
+		metrics.AddWithdrawn(resp.Sum)
+	}
+	return resp, err
+}
+func (s *instrumentedStorage) RefundWithdrawal(ctx context.Context, orderID string, refund datamodels.RefundRequest, actorID int, partnerID int) (datamodels.Refund, error) {
+	ctx, done := observe(ctx, "RefundWithdrawal")
+	resp, err := s.next.RefundWithdrawal(ctx, orderID, refund, actorID, partnerID)
+	done(err)
+	return resp, err
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/luhn"
+)
+
+// The ledger tests need PostgreSQL: set TEST_DATABASE_URI to a database they may migrate and
+// write to. Every test works on users of its own, so the database can be shared and reused.
+
+var (
+	chdirOnce sync.Once
+	chdirErr  error
+	seq       atomic.Int64
+)
+
+func testStorage(t *testing.T) *DBStorage {
+	t.Helper()
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	// migrations are read relative to the repository root
+	chdirOnce.Do(func() { chdirErr = os.Chdir("../..") })
+	if chdirErr != nil {
+		t.Fatal(chdirErr)
+	}
+	dbs, err := NewDBStorage(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbs.db.Close() })
+	return dbs
+}
+
+func newUser(t *testing.T, dbs *DBStorage) (int, string) {
+	t.Helper()
+	ctx := context.Background()
+	login := fmt.Sprintf("ledger-%d-%d", time.Now().UnixNano(), seq.Add(1))
+	if err := dbs.Register(ctx, login, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := dbs.Login(ctx, login, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, login
+}
+
+func newOrder(t *testing.T) string {
+	t.Helper()
+	number, err := luhn.Append(fmt.Sprintf("%d%d", time.Now().UnixNano(), seq.Add(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return number
+}
+
+func exec(t *testing.T, dbs *DBStorage, query string, args ...any) {
+	t.Helper()
+	if _, err := dbs.db.ExecContext(context.Background(), query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// credit uploads an order for the user and has it processed with points, as the accrual poller does.
+func credit(t *testing.T, dbs *DBStorage, userID int, points float32) string {
+	t.Helper()
+	ctx := context.Background()
+	order := newOrder(t)
+	if err := dbs.OrdersPost(ctx, datamodels.OrderInfo{UserID: userID, OrderID: order}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbs.UpdateAccrual(ctx, datamodels.Accrual{Order: order, Status: "PROCESSED", Accrual: points}); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+// balanceCents returns the user's balance and the part of it not reserved by active holds.
+func balanceCents(t *testing.T, dbs *DBStorage, userID int) (balance int, available int) {
+	t.Helper()
+	err := dbs.db.QueryRowContext(context.Background(), `select
+		(select coalesce(sum(accrual), 0) from balance where user_id=$1 and order_status='PROCESSED')
+		+ (select coalesce(sum(amount), 0) from balance_adjustments where user_id=$1),
+		(select coalesce(sum(amount), 0) from holds where user_id=$1 and status='HELD' and expires_at > now());`, userID).
+		Scan(&balance, &available)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance, balance - available
+}
+
+// checkLedger asserts what every balance change must keep: neither the balance nor the balance
+// net of holds is negative, unspent credits never exceed the balance and no withdrawal is
+// refunded beyond what was withdrawn.
+func checkLedger(t *testing.T, dbs *DBStorage, userID int) {
+	t.Helper()
+	ctx := context.Background()
+	balance, available := balanceCents(t, dbs, userID)
+	if balance < 0 || available < 0 {
+		t.Errorf("user %d: balance %d, available %d", userID, balance, available)
+	}
+	var remaining int
+	if err := dbs.db.QueryRowContext(ctx, "select coalesce(sum(remaining), 0) from balance where user_id=$1;", userID).Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining > balance {
+		t.Errorf("user %d: %d unspent credit cents exceed the balance of %d", userID, remaining, balance)
+	}
+	rows, err := dbs.db.QueryContext(ctx, `select b.order_id, -b.accrual, coalesce(sum(r.amount), 0) from balance b
+		left join refunds r on r.withdrawal_order = b.order_id
+		where b.user_id=$1 and b.kind='withdrawal' group by b.order_id, b.accrual;`, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var order string
+		var withdrawn, refunded int
+		if err = rows.Scan(&order, &withdrawn, &refunded); err != nil {
+			t.Fatal(err)
+		}
+		if refunded > withdrawn {
+			t.Errorf("withdrawal %s: refunded %d of %d", order, refunded, withdrawn)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wantBalance(t *testing.T, dbs *DBStorage, userID int, want int) {
+	t.Helper()
+	if got, _ := balanceCents(t, dbs, userID); got != want {
+		t.Errorf("user %d: balance %d, want %d", userID, got, want)
+	}
+}
+func TestWithdrawConcurrently(t *testing.T) {
+	dbs := testStorage(t)
+	id, _ := newUser(t, dbs)
+	credit(t, dbs, id, 10)
+	orders := make([]string, 20)
+	for i := range orders {
+		orders[i] = newOrder(t)
+	}
+	var done, short atomic.Int32
+	var wg sync.WaitGroup
+	for _, order := range orders {
+		wg.Add(1)
+		go func(order string) {
+			defer wg.Done()
+			err := dbs.Withdraw(context.Background(), datamodels.OrderInfo{UserID: id, OrderID: order, Sum: 1})
+			switch {
+			case err == nil:
+				done.Add(1)
+			case errors.Is(err, ErrNotEnoughMoney):
+				short.Add(1)
+			default:
+				t.Error(err)
+			}
+		}(order)
+	}
+	wg.Wait()
+	if done.Load() != 10 || short.Load() != 10 {
+		t.Errorf("%d withdrawals done, %d refused; want 10 each", done.Load(), short.Load())
+	}
+	wantBalance(t, dbs, id, 0)
+	checkLedger(t, dbs, id)
+}
+
+func TestConsumeOldestCreditFirst(t *testing.T) {
+	dbs := testStorage(t)
+	id, _ := newUser(t, dbs)
+	older, newer := credit(t, dbs, id, 5), credit(t, dbs, id, 5)
+	// the older credit expires later; spending still starts with it
+	exec(t, dbs, "update balance set created_at = now() - interval '2 days', expires_at = now() + interval '1 year' where order_id=$1;", older)
+	exec(t, dbs, "update balance set created_at = now() - interval '1 day', expires_at = now() + interval '1 month' where order_id=$1;", newer)
+	if err := dbs.Withdraw(context.Background(), datamodels.OrderInfo{UserID: id, OrderID: newOrder(t), Sum: 7}); err != nil {
+		t.Fatal(err)
+	}
+	for order, want := range map[string]int{older: 0, newer: 300} {
+		var remaining int
+		if err := dbs.db.QueryRow("select remaining from balance where order_id=$1;", order).Scan(&remaining); err != nil {
+			t.Fatal(err)
+		}
+		if remaining != want {
+			t.Errorf("order %s: remaining %d, want %d", order, remaining, want)
+		}
+	}
+	checkLedger(t, dbs, id)
+}
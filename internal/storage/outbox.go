@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/domain"
+	"github.com/lib/pq"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// appendOutbox stores a domain event; callers pass the transaction of the state change it describes.
+func appendOutbox(ctx context.Context, tx execer, eventType string, userID int, orderID string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "insert into outbox (event_type, aggregate_id, user_id, payload) values ($1, $2, $3, $4);", eventType, orderID, userID, data)
+	return err
+}
+
+// PublishOutbox claims up to limit unpublished events for lease, passes them to publish in order
+// and marks them published when it succeeds. The claim is committed before publishing, so slow
+// sinks hold neither a transaction nor row locks; concurrent relays skip claimed rows and pick
+// up the events again once a crashed relay's lease runs out.
+func (dbs *DBStorage) PublishOutbox(ctx context.Context, limit int, lease time.Duration, publish func([]domain.Event) error) (int, error) {
+	rows, err := dbs.db.QueryContext(ctx, `update outbox set claimed_until = now() + make_interval(secs => $2) where id in (
+			select id from outbox where published_at is null and (claimed_until is null or claimed_until < now())
+			order by id limit $1 for update skip locked)
+		returning id, event_type, aggregate_id, user_id, payload, occurred_at;`, limit, lease.Seconds())
+	if err != nil {
+		return 0, ErrInternal
+	}
+	var batch []domain.Event
+	for rows.Next() {
+		var e domain.Event
+		if err = rows.Scan(&e.ID, &e.Type, &e.AggregateID, &e.UserID, &e.Payload, &e.OccurredAt); err != nil {
+			rows.Close()
+			return 0, ErrInternal
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, ErrInternal
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+	ids := make([]int64, len(batch))
+	for i, e := range batch {
+		ids[i] = e.ID
+	}
+	if err = publish(batch); err != nil {
+		if _, releaseErr := dbs.db.ExecContext(ctx, "update outbox set claimed_until=null where id = any($1);", pq.Array(ids)); releaseErr != nil {
+			log.WarnContext(ctx, "release outbox claim", "err", releaseErr)
+		}
+		return 0, err
+	}
+	_, err = dbs.db.ExecContext(ctx, "update outbox set published_at=now(), claimed_until=null where id = any($1);", pq.Array(ids))
+	if err != nil {
+		return 0, ErrInternal
+	}
+	return len(batch), nil
+}
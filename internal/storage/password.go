@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+var ErrResetTokenInvalid = errors.New("invalid or expired password reset token")
+
+func (dbs *DBStorage) GetUserByLogin(ctx context.Context, login string) (datamodels.User, error) {
+	var v datamodels.User
+	err := dbs.db.QueryRowContext(ctx, "select id, login, role, blocked, created_at from users where login=$1;", login).
+		Scan(&v.ID, &v.Login, &v.Role, &v.Blocked, &v.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return datamodels.User{}, ErrNotFound
+	}
+	if err != nil {
+		return datamodels.User{}, ErrInternal
+	}
+	return v, nil
+}
+
+// SetPassword replaces the password of the user and revokes their API keys.
+func (dbs *DBStorage) SetPassword(ctx context.Context, userID int, password string) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "update users set password=$2 where id=$1;", userID, password)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if err = revokeAPIKeys(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// CreatePasswordReset stores a reset token hash and invalidates earlier unused tokens of the user.
+func (dbs *DBStorage) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "update password_resets set used_at=now() where user_id=$1 and used_at is null;", userID); err != nil {
+		return ErrInternal
+	}
+	if _, err = tx.ExecContext(ctx, "insert into password_resets (user_id, token_hash, expires_at) values ($1, $2, $3);", userID, tokenHash, expiresAt); err != nil {
+		return ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// ResetPassword consumes an unexpired reset token, sets the password of its user and revokes their
+// API keys, returning the user ID.
+func (dbs *DBStorage) ResetPassword(ctx context.Context, tokenHash string, password string) (int, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	defer tx.Rollback()
+	var userID int
+	err = tx.QueryRowContext(ctx, "update password_resets set used_at=now() where token_hash=$1 and used_at is null and expires_at > now() returning user_id;", tokenHash).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrResetTokenInvalid
+	}
+	if err != nil {
+		return 0, ErrInternal
+	}
+	if _, err = tx.ExecContext(ctx, "update users set password=$2 where id=$1;", userID, password); err != nil {
+		return 0, ErrInternal
+	}
+	if err = revokeAPIKeys(ctx, tx, userID); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, ErrInternal
+	}
+	return userID, nil
+}
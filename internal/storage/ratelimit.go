@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"math"
+)
+
+// TakeRateToken refills the token bucket key and takes one token when available.
+// It returns the tokens left and whether a token was taken.
+func (dbs *DBStorage) TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, ErrInternal
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "insert into rate_limits (key, tokens, updated_at) values ($1, $2, now()) on conflict (key) do nothing;", key, burst)
+	if err != nil {
+		return 0, false, ErrInternal
+	}
+	var tokens, elapsed float64
+	err = tx.QueryRowContext(ctx, "select tokens, extract(epoch from now() - updated_at) from rate_limits where key=$1 for update;", key).Scan(&tokens, &elapsed)
+	if err != nil {
+		return 0, false, ErrInternal
+	}
+	tokens = math.Min(float64(burst), tokens+math.Max(elapsed, 0)*rate)
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	_, err = tx.ExecContext(ctx, "update rate_limits set tokens=$2, updated_at=now() where key=$1;", key, tokens)
+	if err != nil {
+		return 0, false, ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, false, ErrInternal
+	}
+	return tokens, allowed, nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/domain"
+)
+
+var (
+	ErrWithdrawalNotFound = errors.New("withdrawal not found")
+	ErrRefundExceeds      = errors.New("refund exceeds the withdrawn sum")
+	ErrPartnerNotFound    = errors.New("unknown partner")
+)
+
+// RefundWithdrawal credits back the requested points of the withdrawal for orderID, or the rest of it
+// for a full refund. Refunds of one withdrawal never add up to more than was withdrawn. A non-zero
+// partnerID limits the refund to withdrawals made at that partner's checkout; others are not found.
+func (dbs *DBStorage) RefundWithdrawal(ctx context.Context, orderID string, refund datamodels.RefundRequest, actorID int, partnerID int) (datamodels.Refund, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	defer tx.Rollback()
+	var userID, partner sql.NullInt64
+	var withdrawn int
+	err = tx.QueryRowContext(ctx, "select user_id, -accrual, partner_id from balance where order_id=$1 and kind='withdrawal' for update;", orderID).Scan(&userID, &withdrawn, &partner)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && (!userID.Valid || partnerID != 0 && partner.Int64 != int64(partnerID)) {
+		return datamodels.Refund{}, ErrWithdrawalNotFound
+	}
+	if err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	if err = lockUsers(ctx, tx, int(userID.Int64)); err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	var refunded int
+	if err = tx.QueryRowContext(ctx, "select coalesce(sum(amount), 0) from refunds where withdrawal_order=$1;", orderID).Scan(&refunded); err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	cents := withdrawn - refunded
+	if !refund.Full {
+		cents = 0
+		if refund.Sum != nil {
+			cents = int(math.Round(*refund.Sum * 100))
+		}
+	}
+	if cents <= 0 || refunded+cents > withdrawn {
+		return datamodels.Refund{}, ErrRefundExceeds
+	}
+	resp := datamodels.Refund{Order: orderID, Sum: float64(cents) / 100, Reason: refund.Reason}
+	err = tx.QueryRowContext(ctx, "insert into refunds (withdrawal_order, user_id, amount, reason, refunded_by) values ($1, $2, $3, $4, $5) returning id, created_at;",
+		orderID, userID, cents, refund.Reason, actorID).Scan(&resp.ID, &resp.ProcessedAt)
+	if err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	// refunded points are a new credit and get the lifetime of accrued points
+	_, err = tx.ExecContext(ctx, `insert into balance (user_id, accrual, order_status, kind, refund_id, created_at, remaining, expires_at)
+		values ($1, $2, 'PROCESSED', $3, $4, $5, $2, case when $6 > 0 then now() + make_interval(months => $6) end);`,
+		userID, cents, kindRefund, resp.ID, resp.ProcessedAt, dbs.expiry.LifetimeMonths)
+	if err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	err = recordEvent(ctx, tx, int(userID.Int64), datamodels.EventWithdrawalRefunded, orderID,
+		datamodels.RefundEvent{Order: orderID, Sum: resp.Sum, ProcessedAt: resp.ProcessedAt})
+	if err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	err = appendOutbox(ctx, tx, domain.PointsRefunded, int(userID.Int64), orderID, domain.PointsRefundedPayload{Order: orderID, Points: resp.Sum})
+	if err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return datamodels.Refund{}, ErrInternal
+	}
+	return resp, nil
+}
+
+// refundsByOrder returns the refunds of the user's withdrawals keyed by withdrawal order.
+func (dbs *DBStorage) refundsByOrder(ctx context.Context, userID int) (map[string][]datamodels.Refund, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select id, withdrawal_order, amount, reason, created_at from refunds where user_id=$1 order by created_at, id;", userID)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	resp := make(map[string][]datamodels.Refund)
+	for rows.Next() {
+		var v datamodels.Refund
+		var cents int
+		if err = rows.Scan(&v.ID, &v.Order, &cents, &v.Reason, &v.ProcessedAt); err != nil {
+			return nil, ErrInternal
+		}
+		v.Sum = float64(cents) / 100
+		resp[v.Order] = append(resp[v.Order], v)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	return resp, nil
+}
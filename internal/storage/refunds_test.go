@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+func newPartner(t *testing.T, dbs *DBStorage) (int, string) {
+	t.Helper()
+	id, login := newUser(t, dbs)
+	exec(t, dbs, "update users set role=$2 where id=$1;", id, datamodels.RolePartner)
+	return id, login
+}
+
+func TestRefunds(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+	admin, _ := newUser(t, dbs)
+	partner, partnerLogin := newPartner(t, dbs)
+	other, _ := newPartner(t, dbs)
+	id, _ := newUser(t, dbs)
+	credit(t, dbs, id, 10)
+
+	if err := dbs.Withdraw(ctx, datamodels.OrderInfo{UserID: id, OrderID: newOrder(t), Sum: 1, Partner: "no such partner"}); !errors.Is(err, ErrPartnerNotFound) {
+		t.Fatalf("unknown partner: %v", err)
+	}
+	order := newOrder(t)
+	if err := dbs.Withdraw(ctx, datamodels.OrderInfo{UserID: id, OrderID: order, Sum: 6, Partner: partnerLogin}); err != nil {
+		t.Fatal(err)
+	}
+	two, one := 2.0, 1.0
+	steps := []struct {
+		name      string
+		req       datamodels.RefundRequest
+		partnerID int
+		want      error
+		sum       float64
+	}{
+		{"admin, partial", datamodels.RefundRequest{Sum: &two}, 0, nil, 2},
+		{"other partner", datamodels.RefundRequest{Full: true}, other, ErrWithdrawalNotFound, 0},
+		{"no sum", datamodels.RefundRequest{}, 0, ErrRefundExceeds, 0},
+		{"own partner, full", datamodels.RefundRequest{Full: true}, partner, nil, 4},
+		{"after full refund", datamodels.RefundRequest{Sum: &one}, 0, ErrRefundExceeds, 0},
+	}
+	for _, s := range steps {
+		refund, err := dbs.RefundWithdrawal(ctx, order, s.req, admin, s.partnerID)
+		if !errors.Is(err, s.want) {
+			t.Fatalf("%s: %v, want %v", s.name, err, s.want)
+		}
+		if err == nil && refund.Sum != s.sum {
+			t.Errorf("%s: refunded %v, want %v", s.name, refund.Sum, s.sum)
+		}
+	}
+	wantBalance(t, dbs, id, 1000)
+
+	order = newOrder(t)
+	if err := dbs.Withdraw(ctx, datamodels.OrderInfo{UserID: id, OrderID: order, Sum: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbs.RefundWithdrawal(ctx, order, datamodels.RefundRequest{Full: true}, partner, partner); !errors.Is(err, ErrWithdrawalNotFound) {
+		t.Errorf("partner refunding a withdrawal outside its checkout: %v", err)
+	}
+	half := 0.5
+	var done atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := dbs.RefundWithdrawal(context.Background(), order, datamodels.RefundRequest{Sum: &half}, admin, 0)
+			switch {
+			case err == nil:
+				done.Add(1)
+			case !errors.Is(err, ErrRefundExceeds):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if done.Load() != 6 {
+		t.Errorf("%d refunds of 0.5 went through for a withdrawal of 3, want 6", done.Load())
+	}
+	wantBalance(t, dbs, id, 1000)
+	checkLedger(t, dbs, id)
+}
@@ -1,24 +1,29 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
+	"github.com/N0rkton/gophermart/internal/audit"
 	"github.com/N0rkton/gophermart/internal/datamodels"
-	"github.com/N0rkton/gophermart/internal/utils"
+	"github.com/N0rkton/gophermart/internal/domain"
+	"github.com/N0rkton/gophermart/internal/logger"
+	"github.com/N0rkton/gophermart/internal/luhn"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"log"
+	"github.com/lib/pq"
 	"math"
-	"strconv"
 
 	"time"
 )
 
+var log = logger.For("storage")
+
 // md5 cash for password
 var (
 	ErrNotFound         = errors.New("not found")
@@ -29,24 +34,79 @@ var (
 	ErrInternal         = errors.New("server error")
 	ErrNoData           = errors.New("no orders")
 	ErrNotEnoughMoney   = errors.New("not enough money")
+	ErrUserBlocked      = errors.New("user is blocked")
+	ErrOrderNotFound    = errors.New("order not found")
+	ErrOrderState       = errors.New("order status does not allow this operation")
 )
 
 type Storage interface {
-	Register(login string, password string) error
-	Login(login string, password string) (int, error)
-	OrdersPost(order datamodels.OrderInfo) error
-	GetOrderList(order datamodels.OrderInfo) ([]datamodels.Order, error)
-	Balance(order datamodels.OrderInfo) (datamodels.Balance, error)
-	Withdraw(order datamodels.OrderInfo) error
-	GetWithdrawList(order datamodels.OrderInfo) ([]datamodels.Withdrawals, error)
-	GetAllOrdersForAccrual() ([]string, error)
-	UpdateAccrual(accrual datamodels.Accrual) error
+	Register(ctx context.Context, login string, password string) error
+	Login(ctx context.Context, login string, password string) (int, error)
+	OrdersPost(ctx context.Context, order datamodels.OrderInfo) error
+	GetOrderList(ctx context.Context, order datamodels.OrderInfo) ([]datamodels.Order, error)
+	Balance(ctx context.Context, order datamodels.OrderInfo) (datamodels.Balance, error)
+	Withdraw(ctx context.Context, order datamodels.OrderInfo) error
+	GetWithdrawList(ctx context.Context, order datamodels.OrderInfo) ([]datamodels.Withdrawals, error)
+	GetAllOrdersForAccrual(ctx context.Context) ([]string, error)
+	UpdateAccrual(ctx context.Context, accrual datamodels.Accrual) (float64, error)
+	GetEventsSince(ctx context.Context, userID int, afterID int64, limit int) ([]datamodels.Event, error)
+	LastEventID(ctx context.Context, userID int) (int64, error)
+	CreateWebhook(ctx context.Context, endpoint datamodels.WebhookEndpoint) (datamodels.WebhookEndpoint, error)
+	GetWebhooks(ctx context.Context, userID int) ([]datamodels.WebhookEndpoint, error)
+	DeleteWebhook(ctx context.Context, userID int, id int) error
+	GetWebhookDeliveries(ctx context.Context, userID int, status string, limit int) ([]datamodels.WebhookDelivery, error)
+	ReplayWebhookDelivery(ctx context.Context, userID int, id int64) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]datamodels.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, id int64) error
+	FailWebhookDelivery(ctx context.Context, id int64, reason string, retryAt time.Time) error
+	PublishOutbox(ctx context.Context, limit int, lease time.Duration, publish func([]domain.Event) error) (int, error)
+	GetUser(ctx context.Context, id int) (datamodels.User, error)
+	FindUsers(ctx context.Context, login string, limit int) ([]datamodels.User, error)
+	SetUserRole(ctx context.Context, login string, role string) error
+	SetUserBlocked(ctx context.Context, id int, blocked bool) error
+	RequeueOrder(ctx context.Context, orderID string) error
+	InvalidateOrder(ctx context.Context, orderID string) error
+	AdjustBalance(ctx context.Context, userID int, adminID int, adj datamodels.Adjustment) error
+	AppendAudit(ctx context.Context, e audit.Entry) error
+	QueryAudit(ctx context.Context, f audit.Filter) ([]audit.Entry, error)
+	VerifyAudit(ctx context.Context) (int, int64, error)
+	AttemptsLockedUntil(ctx context.Context, keys []string) (time.Time, error)
+	RecordAttempt(ctx context.Context, key string, window time.Duration, lockFor func(attempts int) time.Duration) (time.Time, error)
+	ForgiveAttempt(ctx context.Context, key string, lockFor func(attempts int) time.Duration) error
+	ClearAttempts(ctx context.Context, key string) error
+	TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error)
+	GetTwoFactor(ctx context.Context, userID int) (datamodels.TwoFactor, error)
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTwoFactor(ctx context.Context, userID int, step int64, codeHashes []string) error
+	DisableTwoFactor(ctx context.Context, userID int) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	GetUserByLogin(ctx context.Context, login string) (datamodels.User, error)
+	SetPassword(ctx context.Context, userID int, password string) error
+	CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash string, password string) (int, error)
+	DeleteAccount(ctx context.Context, userID int) error
+	PurgeDeletedUsers(ctx context.Context, grace time.Duration) (int, error)
+	ExpirePoints(ctx context.Context, limit int) (float64, error)
+	GetTier(ctx context.Context, userID int) (datamodels.TierStatus, error)
+	RecalculateTiers(ctx context.Context) (int, error)
+	Transfer(ctx context.Context, fromID int, toLogin string, sum float64, dailyLimit float64) (datamodels.Transfer, error)
+	GetTransfers(ctx context.Context, userID int) ([]datamodels.Transfer, error)
+	CreateHold(ctx context.Context, order datamodels.OrderInfo, ttl time.Duration) (datamodels.Hold, error)
+	CaptureHold(ctx context.Context, userID int, id int) (datamodels.Hold, error)
+	CancelHold(ctx context.Context, userID int, id int) (datamodels.Hold, error)
+	GetHolds(ctx context.Context, userID int) ([]datamodels.Hold, error)
+	ExpireHolds(ctx context.Context) (int, error)
+	RefundWithdrawal(ctx context.Context, orderID string, refund datamodels.RefundRequest, actorID int, partnerID int) (datamodels.Refund, error)
+	CountOrdersByStatus(ctx context.Context) (map[string]int, error)
+	OrdersPostBatch(ctx context.Context, userID int, orders []string) ([]datamodels.BatchOrderResult, error)
 }
 type DBStorage struct {
-	db *sql.DB
+	db     *sql.DB
+	expiry ExpiryPolicy
 }
 
-func NewDBStorage(path string) (Storage, error) {
+func NewDBStorage(path string) (*DBStorage, error) {
 	if path == "" {
 		return nil, errors.New("invalid db address")
 	}
@@ -70,34 +130,39 @@ func NewDBStorage(path string) (Storage, error) {
 	}
 	return &DBStorage{db: db}, nil
 }
-func (dbs *DBStorage) Register(login string, password string) error {
-	_, err := dbs.db.Exec("insert into users (login, password) values ($1, $2);", login, password)
+func (dbs *DBStorage) Register(ctx context.Context, login string, password string) error {
+	_, err := dbs.db.ExecContext(ctx, "insert into users (login, password) values ($1, $2);", login, password)
 	return err
 }
-func (dbs *DBStorage) Login(login string, password string) (int, error) {
-	rows := dbs.db.QueryRow("select id,password from users where login=$1 limit 1;", login)
+func (dbs *DBStorage) Login(ctx context.Context, login string, password string) (int, error) {
+	rows := dbs.db.QueryRowContext(ctx, "select id,password,blocked from users where login=$1 limit 1;", login)
 	var v datamodels.Auth
-	err := rows.Scan(&v.ID, &v.Password)
+	err := rows.Scan(&v.ID, &v.Password, &v.Blocked)
 	if err != nil {
 		return 0, ErrNotFound
 	}
 	if v.Password != password {
 		return 0, ErrWrongPassword
 	}
+	if v.Blocked {
+		return 0, ErrUserBlocked
+	}
 	return v.ID, nil
 }
-func (dbs *DBStorage) OrdersPost(order datamodels.OrderInfo) error {
-	check := utils.Checksum(order.OrderID)
-	if check != 0 {
+func (dbs *DBStorage) OrdersPost(ctx context.Context, order datamodels.OrderInfo) error {
+	if !luhn.Valid(order.OrderID) {
 		return ErrInvalidOrder
 	}
 	orderTime := time.Now().UTC()
-	_, err := dbs.db.Exec("insert into balance (user_id, order_id,created_at) values ($1, $2,$3);", order.UserID, strconv.Itoa(order.OrderID), orderTime.Format(time.RFC3339))
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-		rows := dbs.db.QueryRow("select user_id from balance where order_id=$1 limit 1;", strconv.Itoa(order.OrderID))
-		var v int
-		err := rows.Scan(&v)
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	var v int
+	err = tx.QueryRowContext(ctx, "insert into balance (user_id, order_id,created_at) values ($1, $2,$3) on conflict (order_id) do nothing returning user_id;", order.UserID, order.OrderID, orderTime.Format(time.RFC3339)).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, "select user_id from balance where order_id=$1 limit 1;", order.OrderID).Scan(&v)
 		if err != nil {
 			return ErrInternal
 		}
@@ -109,11 +174,18 @@ func (dbs *DBStorage) OrdersPost(order datamodels.OrderInfo) error {
 	if err != nil {
 		return ErrInternal
 	}
+	err = appendOutbox(ctx, tx, domain.OrderUploaded, order.UserID, order.OrderID, domain.OrderUploadedPayload{Order: order.OrderID})
+	if err != nil {
+		return ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
 	return nil
 }
 
-func (dbs *DBStorage) GetOrderList(order datamodels.OrderInfo) ([]datamodels.Order, error) {
-	rows, err := dbs.db.Query("select order_id,order_status,accrual, created_at from balance where user_id=$1 ORDER BY created_at DESC ;", order.UserID)
+func (dbs *DBStorage) GetOrderList(ctx context.Context, order datamodels.OrderInfo) ([]datamodels.Order, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select order_id,order_status,accrual, created_at from balance where user_id=$1 and kind='order' ORDER BY created_at DESC ;", order.UserID)
 	if err != nil {
 		return nil, ErrInternal
 	}
@@ -137,8 +209,8 @@ func (dbs *DBStorage) GetOrderList(order datamodels.OrderInfo) ([]datamodels.Ord
 	return resp, nil
 }
 
-func (dbs *DBStorage) Balance(order datamodels.OrderInfo) (datamodels.Balance, error) {
-	rows, err := dbs.db.Query("select accrual from balance where user_id=$1 and order_status='PROCESSED';", order.UserID)
+func (dbs *DBStorage) Balance(ctx context.Context, order datamodels.OrderInfo) (datamodels.Balance, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select accrual, kind from balance where user_id=$1 and order_status='PROCESSED';", order.UserID)
 	if err != nil {
 		return datamodels.Balance{}, ErrNoData
 	}
@@ -149,32 +221,84 @@ func (dbs *DBStorage) Balance(order datamodels.OrderInfo) (datamodels.Balance, e
 	var resp datamodels.Balance
 	for rows.Next() {
 		var accrual float64
-		err = rows.Scan(&accrual)
+		var kind string
+		err = rows.Scan(&accrual, &kind)
 		if err != nil {
 			return datamodels.Balance{}, ErrInternal
 		}
 		resp.Current += accrual / 100
-		if accrual < 0 {
+		switch kind {
+		case kindWithdrawal:
 			resp.Withdrawn += math.Abs(accrual / 100)
+		case kindRefund:
+			resp.Withdrawn -= accrual / 100
 		}
 	}
-
+	var adjusted float64
+	err = dbs.db.QueryRowContext(ctx, "select coalesce(sum(amount), 0) from balance_adjustments where user_id=$1;", order.UserID).Scan(&adjusted)
+	if err != nil {
+		return datamodels.Balance{}, ErrInternal
+	}
+	resp.Current += adjusted / 100
+	var held float64
+	err = dbs.db.QueryRowContext(ctx, "select coalesce(sum(amount), 0) from holds where user_id=$1 and status='HELD' and expires_at > now();", order.UserID).Scan(&held)
+	if err != nil {
+		return datamodels.Balance{}, ErrInternal
+	}
+	resp.Held = held / 100
+	resp.Current -= resp.Held
+	if resp.Expiring, err = dbs.expiringSoon(ctx, order.UserID); err != nil {
+		return datamodels.Balance{}, err
+	}
 	return resp, nil
 }
-func (dbs *DBStorage) Withdraw(order datamodels.OrderInfo) error {
-	check := utils.Checksum(order.OrderID)
-	if check != 0 {
+func (dbs *DBStorage) Withdraw(ctx context.Context, order datamodels.OrderInfo) error {
+	if !luhn.Valid(order.OrderID) {
 		return ErrInvalidOrder
 	}
-	userBalance, err := dbs.Balance(order)
+	cents := int(math.Round(order.Sum * 100))
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if err = lockUsers(ctx, tx, order.UserID); err != nil {
+		return ErrInternal
+	}
+	available, err := availableCents(ctx, tx, order.UserID)
 	if err != nil {
 		return ErrInternal
 	}
-	if userBalance.Current < order.Sum {
+	if available < cents {
 		return ErrNotEnoughMoney
 	}
-	orderTime := time.Now().Format(time.RFC3339)
-	_, err = dbs.db.Exec("insert into balance (user_id, order_id,created_at,accrual,order_status) values ($1, $2,$3,$4,$5);", order.UserID, strconv.Itoa(order.OrderID), orderTime, int(-order.Sum*100), "PROCESSED")
+	partnerID, err := lookupPartner(ctx, tx, order.Partner)
+	if err != nil {
+		return err
+	}
+	if err = postWithdrawal(ctx, tx, order.UserID, order.OrderID, cents, partnerID); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// postWithdrawal debits cents from the user for an order not reserved by an active hold;
+// callers have checked the balance under lockUsers.
+func postWithdrawal(ctx context.Context, tx *sql.Tx, userID int, orderID string, cents int, partnerID sql.NullInt64) error {
+	var held bool
+	err := tx.QueryRowContext(ctx, "select exists (select 1 from holds where order_id=$1 and status='HELD' and expires_at > now());", orderID).Scan(&held)
+	if err != nil {
+		return ErrInternal
+	}
+	if held {
+		return ErrInvalidOrder
+	}
+	processedAt := time.Now()
+	sum := float64(cents) / 100
+	_, err = tx.ExecContext(ctx, "insert into balance (user_id, order_id,created_at,accrual,order_status,kind,partner_id) values ($1, $2,$3,$4,$5,$6,$7);", userID, orderID, processedAt.Format(time.RFC3339), -cents, "PROCESSED", kindWithdrawal, partnerID)
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 		return ErrInvalidOrder
@@ -182,11 +306,54 @@ func (dbs *DBStorage) Withdraw(order datamodels.OrderInfo) error {
 	if err != nil {
 		return ErrInternal
 	}
+	if _, err = consumeCredits(ctx, tx, userID, cents); err != nil {
+		return ErrInternal
+	}
+	err = recordEvent(ctx, tx, userID, datamodels.EventWithdrawalPosted, orderID,
+		datamodels.WithdrawalEvent{Order: orderID, Sum: sum, ProcessedAt: processedAt})
+	if err != nil {
+		return ErrInternal
+	}
+	err = appendOutbox(ctx, tx, domain.PointsWithdrawn, userID, orderID, domain.PointsWithdrawnPayload{Order: orderID, Points: sum})
+	if err != nil {
+		return ErrInternal
+	}
 	return nil
 }
-func (dbs *DBStorage) GetWithdrawList(order datamodels.OrderInfo) ([]datamodels.Withdrawals, error) {
 
-	rows, err := dbs.db.Query("select order_id,accrual, created_at from balance where user_id=$1 and accrual<0 ORDER BY created_at DESC ;", order.UserID)
+// lookupPartner resolves the login of an active partner account; an empty login means no partner.
+func lookupPartner(ctx context.Context, tx *sql.Tx, login string) (sql.NullInt64, error) {
+	var id sql.NullInt64
+	if login == "" {
+		return id, nil
+	}
+	err := tx.QueryRowContext(ctx, "select id from users where login=$1 and role=$2 and not blocked and deleted_at is null;", login, datamodels.RolePartner).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, ErrPartnerNotFound
+	}
+	if err != nil {
+		return id, ErrInternal
+	}
+	return id, nil
+}
+
+// lockUsers serialises balance changes of the users; rows are locked in id order so transfers cannot deadlock.
+func lockUsers(ctx context.Context, tx *sql.Tx, ids ...int) error {
+	_, err := tx.ExecContext(ctx, "select id from users where id = any($1) order by id for update;", pq.Array(ids))
+	return err
+}
+
+// availableCents returns the spendable balance of the user in cents, net of active holds.
+func availableCents(ctx context.Context, tx *sql.Tx, userID int) (int, error) {
+	var cents int
+	err := tx.QueryRowContext(ctx, `select (select coalesce(sum(accrual), 0) from balance where user_id=$1 and order_status='PROCESSED')
+		+ (select coalesce(sum(amount), 0) from balance_adjustments where user_id=$1)
+		- (select coalesce(sum(amount), 0) from holds where user_id=$1 and status='HELD' and expires_at > now());`, userID).Scan(&cents)
+	return cents, err
+}
+func (dbs *DBStorage) GetWithdrawList(ctx context.Context, order datamodels.OrderInfo) ([]datamodels.Withdrawals, error) {
+
+	rows, err := dbs.db.QueryContext(ctx, "select order_id,accrual, created_at from balance where user_id=$1 and kind='withdrawal' ORDER BY created_at DESC ;", order.UserID)
 	if err != nil {
 		return nil, ErrNoData
 	}
@@ -207,10 +374,20 @@ func (dbs *DBStorage) GetWithdrawList(order datamodels.OrderInfo) ([]datamodels.
 	if resp == nil {
 		return nil, ErrNoData
 	}
+	refunds, err := dbs.refundsByOrder(ctx, order.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp {
+		resp[i].Refunds = refunds[resp[i].Order]
+		for _, refund := range resp[i].Refunds {
+			resp[i].Refunded += refund.Sum
+		}
+	}
 	return resp, nil
 }
-func (dbs *DBStorage) GetAllOrdersForAccrual() ([]string, error) {
-	rows, err := dbs.db.Query("select order_id from balance where order_status!='INVALID' and order_status!='PROCESSED';")
+func (dbs *DBStorage) GetAllOrdersForAccrual(ctx context.Context) ([]string, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select order_id from balance where kind='order' and order_status!='INVALID' and order_status!='PROCESSED' and user_id is not null;")
 	if err != nil {
 		return nil, ErrNoData
 	}
@@ -228,11 +405,144 @@ func (dbs *DBStorage) GetAllOrdersForAccrual() ([]string, error) {
 	}
 	return allOrders, nil
 }
-func (dbs *DBStorage) UpdateAccrual(accrual datamodels.Accrual) error {
-	accrual.Accrual *= 100
-	_, err := dbs.db.Exec("UPDATE balance SET accrual = $1, order_status=$2 WHERE order_id = $3 ;", int(accrual.Accrual), accrual.Status, accrual.Order)
+
+// UpdateAccrual stores the accrual service's verdict on an order and returns the points it
+// credited to the user, after the tier multiplier; unknown orders and unchanged verdicts credit none.
+func (dbs *DBStorage) UpdateAccrual(ctx context.Context, accrual datamodels.Accrual) (float64, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		return 0, ErrInternal
 	}
-	return nil
+	defer tx.Rollback()
+	var userID int
+	err = tx.QueryRowContext(ctx, "select user_id from balance where order_id=$1 and kind='order';", accrual.Order).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "update accrual", "order", accrual.Order, "err", err)
+		return 0, err
+	}
+	// the user's lock comes before the order row, as in withdrawals, and orders the user's events
+	if err = lockUsers(ctx, tx, userID); err != nil {
+		log.ErrorContext(ctx, "update accrual", "order", accrual.Order, "err", err)
+		return 0, err
+	}
+	var before int
+	var status string
+	var multiplier float64
+	err = tx.QueryRowContext(ctx, `select b.accrual, b.order_status, coalesce(t.multiplier, 1) from balance b
+		join users u on u.id = b.user_id left join tiers t on t.name = u.tier
+		where b.order_id=$1 and b.kind='order' for update of b;`, accrual.Order).Scan(&before, &status, &multiplier)
+	if err != nil {
+		log.ErrorContext(ctx, "update accrual", "order", accrual.Order, "err", err)
+		return 0, err
+	}
+	if status != "PROCESSED" {
+		before = 0
+	}
+	// the tier multiplier applies when the order is credited
+	if accrual.Status == "PROCESSED" {
+		accrual.Accrual = float32(math.Round(float64(accrual.Accrual)*multiplier*100) / 100)
+	}
+	cents := int(math.Round(float64(accrual.Accrual) * 100))
+	// a credited order starts the lifetime of its points, consumed FIFO through remaining
+	res, err := tx.ExecContext(ctx, `UPDATE balance SET accrual = $1, order_status=$2,
+		remaining = case when $2 = 'PROCESSED' then greatest($1, 0) else 0 end,
+		expires_at = case when $2 = 'PROCESSED' and $4 > 0 then now() + make_interval(months => $4) end
+		WHERE order_id = $3 and kind='order' and (accrual, order_status) is distinct from ($1, $2);`,
+		cents, accrual.Status, accrual.Order, dbs.expiry.LifetimeMonths)
+	if err != nil {
+		log.ErrorContext(ctx, "update accrual", "order", accrual.Order, "err", err)
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	err = recordEvent(ctx, tx, userID, datamodels.EventOrderStatusChanged, accrual.Order,
+		datamodels.OrderStatusEvent{Order: accrual.Order, Status: accrual.Status, Accrual: accrual.Accrual})
+	if err != nil {
+		return 0, err
+	}
+	err = appendOutbox(ctx, tx, domain.OrderStatusChanged, userID, accrual.Order, domain.OrderStatusChangedPayload{Order: accrual.Order, Status: accrual.Status})
+	if err != nil {
+		return 0, err
+	}
+	if accrual.Status == "PROCESSED" && accrual.Accrual > 0 {
+		err = appendOutbox(ctx, tx, domain.PointsAccrued, userID, accrual.Order, domain.PointsAccruedPayload{Order: accrual.Order, Points: float64(accrual.Accrual)})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	if accrual.Status != "PROCESSED" {
+		cents = 0
+	}
+	return float64(cents-before) / 100, nil
+}
+func (dbs *DBStorage) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select order_status, count(*) from balance where kind='order' group by order_status;")
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		err = rows.Scan(&status, &n)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		counts[status] = n
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	return counts, nil
+}
+
+// OrdersPostBatch inserts all orders of a user in one transaction and reports the outcome of every item.
+func (dbs *DBStorage) OrdersPostBatch(ctx context.Context, userID int, orders []string) ([]datamodels.BatchOrderResult, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer tx.Rollback()
+	orderTime := time.Now().UTC().Format(time.RFC3339)
+	resp := make([]datamodels.BatchOrderResult, 0, len(orders))
+	for _, order := range orders {
+		if !luhn.Valid(order) {
+			resp = append(resp, datamodels.BatchOrderResult{Order: order, Status: datamodels.BatchInvalid})
+			continue
+		}
+		var owner int
+		err = tx.QueryRowContext(ctx, "insert into balance (user_id, order_id, created_at) values ($1, $2, $3) on conflict (order_id) do nothing returning user_id;", userID, order, orderTime).Scan(&owner)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = tx.QueryRowContext(ctx, "select user_id from balance where order_id=$1 limit 1;", order).Scan(&owner)
+			if err != nil {
+				return nil, ErrInternal
+			}
+			status := datamodels.BatchAnotherUser
+			if owner == userID {
+				status = datamodels.BatchAlreadyUploaded
+			}
+			resp = append(resp, datamodels.BatchOrderResult{Order: order, Status: status})
+			continue
+		}
+		if err != nil {
+			return nil, ErrInternal
+		}
+		err = appendOutbox(ctx, tx, domain.OrderUploaded, userID, order, domain.OrderUploadedPayload{Order: order})
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, datamodels.BatchOrderResult{Order: order, Status: datamodels.BatchAccepted})
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, ErrInternal
+	}
+	return resp, nil
 }
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+// accrued12m sums the points credited to users.id over the rolling tier window.
+const accrued12m = `(select coalesce(sum(b.accrual), 0) from balance b where b.user_id = u.id and b.kind = 'order'
+	and b.order_status = 'PROCESSED' and b.created_at > now() - interval '12 months')`
+
+// GetTier returns the user's tier, the progress to the next one and the tier history, newest first.
+func (dbs *DBStorage) GetTier(ctx context.Context, userID int) (datamodels.TierStatus, error) {
+	var resp datamodels.TierStatus
+	var accrued, minAccrual int
+	err := dbs.db.QueryRowContext(ctx, "select u.tier, t.multiplier, t.min_accrual, "+accrued12m+" from users u join tiers t on t.name = u.tier where u.id=$1;", userID).
+		Scan(&resp.Tier, &resp.Multiplier, &minAccrual, &accrued)
+	if errors.Is(err, sql.ErrNoRows) {
+		return datamodels.TierStatus{}, ErrNotFound
+	}
+	if err != nil {
+		return datamodels.TierStatus{}, ErrInternal
+	}
+	resp.Accrued = float64(accrued) / 100
+	var next datamodels.TierNext
+	var nextMin int
+	err = dbs.db.QueryRowContext(ctx, "select name, multiplier, min_accrual from tiers where min_accrual > $1 order by min_accrual limit 1;", minAccrual).
+		Scan(&next.Tier, &next.Multiplier, &nextMin)
+	switch {
+	case err == nil:
+		next.MinAccrual = float64(nextMin) / 100
+		next.Remaining = float64(max(nextMin-accrued, 0)) / 100
+		resp.Next = &next
+	case !errors.Is(err, sql.ErrNoRows):
+		return datamodels.TierStatus{}, ErrInternal
+	}
+	rows, err := dbs.db.QueryContext(ctx, "select old_tier, new_tier, accrued, changed_at from tier_history where user_id=$1 order by changed_at desc, id desc;", userID)
+	if err != nil {
+		return datamodels.TierStatus{}, ErrInternal
+	}
+	defer rows.Close()
+	resp.History = []datamodels.TierChange{}
+	for rows.Next() {
+		var c datamodels.TierChange
+		var cents int
+		if err = rows.Scan(&c.From, &c.To, &cents, &c.ChangedAt); err != nil {
+			return datamodels.TierStatus{}, ErrInternal
+		}
+		c.Accrued = float64(cents) / 100
+		resp.History = append(resp.History, c)
+	}
+	if rows.Err() != nil {
+		return datamodels.TierStatus{}, ErrInternal
+	}
+	return resp, nil
+}
+
+// RecalculateTiers moves every active user to the highest tier their rolling accruals reach,
+// records the changes and returns how many users changed tier.
+func (dbs *DBStorage) RecalculateTiers(ctx context.Context) (int, error) {
+	res, err := dbs.db.ExecContext(ctx, `with target as (
+			select u.id, u.tier as old_tier, a.accrued,
+				(select name from tiers where min_accrual <= a.accrued order by min_accrual desc limit 1) as new_tier
+			from users u cross join lateral (select `+accrued12m+` as accrued) a
+			where u.deleted_at is null
+		), changed as (
+			update users u set tier = t.new_tier from target t
+			where u.id = t.id and t.new_tier is not null and t.new_tier <> u.tier
+			returning u.id, t.old_tier, t.new_tier, t.accrued
+		)
+		insert into tier_history (user_id, old_tier, new_tier, accrued) select id, old_tier, new_tier, accrued from changed;`)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
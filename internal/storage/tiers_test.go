@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+func TestTiers(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+	earner, _ := newUser(t, dbs)
+	receiver, receiverLogin := newUser(t, dbs)
+	credit(t, dbs, earner, 1000)
+	if _, err := dbs.Transfer(ctx, earner, receiverLogin, 1000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbs.RecalculateTiers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// tiers follow accrued points, not the balance or points received
+	for id, want := range map[int]string{earner: "Silver", receiver: "Bronze"} {
+		tier, err := dbs.GetTier(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tier.Tier != want {
+			t.Errorf("user %d: tier %s, want %s", id, tier.Tier, want)
+		}
+	}
+	credit(t, dbs, earner, 10)
+	wantBalance(t, dbs, earner, 1050)
+	checkLedger(t, dbs, earner)
+	checkLedger(t, dbs, receiver)
+}
+
+func TestUpdateAccrualReportsCreditedPoints(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+	id, _ := newUser(t, dbs)
+	order := newOrder(t)
+	if err := dbs.OrdersPost(ctx, datamodels.OrderInfo{UserID: id, OrderID: order}); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		order  string
+		status string
+		points float32
+		want   float64
+	}{
+		{newOrder(t), "PROCESSED", 10, 0},
+		{order, "PROCESSING", 0, 0},
+		{order, "PROCESSED", 10, 10},
+		{order, "PROCESSED", 10, 0},
+		{order, "PROCESSED", 12.5, 2.5},
+	} {
+		got, err := dbs.UpdateAccrual(ctx, datamodels.Accrual{Order: step.order, Status: step.status, Accrual: step.points})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("%s %v: credited %v, want %v", step.status, step.points, got, step.want)
+		}
+	}
+	wantBalance(t, dbs, id, 1250)
+	checkLedger(t, dbs, id)
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/domain"
+)
+
+var (
+	ErrTransferRejected = errors.New("the recipient cannot receive points")
+	ErrTransferToSelf   = errors.New("cannot transfer points to yourself")
+	ErrTransferLimit    = errors.New("daily transfer limit exceeded")
+)
+
+// Transfer moves sum points from the user to the account with login toLogin. Both balances are
+// locked for the transaction; dailyLimit caps the points sent over 24 hours, 0 means no limit.
+// The recipient's points expire no later than the earliest of the spent ones.
+// Unknown, blocked and deleted recipients are all rejected with ErrTransferRejected, and only
+// after the sender's limit and balance passed, so the answer does not tell which logins exist.
+func (dbs *DBStorage) Transfer(ctx context.Context, fromID int, toLogin string, sum float64, dailyLimit float64) (datamodels.Transfer, error) {
+	cents := int(math.Round(sum * 100))
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	defer tx.Rollback()
+	var toID int
+	err = tx.QueryRowContext(ctx, "select id from users where login=$1 and deleted_at is null and not blocked;", toLogin).Scan(&toID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	if toID == fromID {
+		return datamodels.Transfer{}, ErrTransferToSelf
+	}
+	if err = lockUsers(ctx, tx, fromID, toID); err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	// the recipient may have been blocked or deleted before the lock was granted
+	if toID != 0 {
+		var active bool
+		err = tx.QueryRowContext(ctx, "select deleted_at is null and not blocked from users where id=$1;", toID).Scan(&active)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return datamodels.Transfer{}, ErrInternal
+		}
+		if !active {
+			toID = 0
+		}
+	}
+	if dailyLimit > 0 {
+		var sent int
+		err = tx.QueryRowContext(ctx, "select coalesce(sum(amount), 0) from transfers where from_user_id=$1 and created_at > now() - interval '24 hours';", fromID).Scan(&sent)
+		if err != nil {
+			return datamodels.Transfer{}, ErrInternal
+		}
+		if sent+cents > int(math.Round(dailyLimit*100)) {
+			return datamodels.Transfer{}, ErrTransferLimit
+		}
+	}
+	available, err := availableCents(ctx, tx, fromID)
+	if err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	if available < cents {
+		return datamodels.Transfer{}, ErrNotEnoughMoney
+	}
+	if toID == 0 {
+		return datamodels.Transfer{}, ErrTransferRejected
+	}
+	resp := datamodels.Transfer{Direction: datamodels.TransferOut, Counterparty: toLogin, Sum: sum}
+	err = tx.QueryRowContext(ctx, "insert into transfers (from_user_id, to_user_id, amount) values ($1, $2, $3) returning id, created_at;", fromID, toID, cents).
+		Scan(&resp.ID, &resp.ProcessedAt)
+	if err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	expiresAt, err := consumeCredits(ctx, tx, fromID, cents)
+	if err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	_, err = tx.ExecContext(ctx, `insert into balance (user_id, accrual, order_status, kind, transfer_id, created_at, remaining, expires_at)
+		values ($1, $3, 'PROCESSED', $4, $5, $6, 0, null), ($2, $7, 'PROCESSED', $4, $5, $6, $7, $8);`,
+		fromID, toID, -cents, kindTransfer, resp.ID, resp.ProcessedAt, cents, expiresAt)
+	if err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	err = appendOutbox(ctx, tx, domain.PointsTransferred, fromID, "transfer:"+strconv.Itoa(resp.ID),
+		domain.PointsTransferredPayload{Transfer: resp.ID, From: fromID, To: toID, Points: sum})
+	if err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return datamodels.Transfer{}, ErrInternal
+	}
+	return resp, nil
+}
+
+// GetTransfers lists the transfers sent and received by the user, newest first.
+func (dbs *DBStorage) GetTransfers(ctx context.Context, userID int) ([]datamodels.Transfer, error) {
+	rows, err := dbs.db.QueryContext(ctx, `select t.id, case when t.from_user_id=$1 then 'out' else 'in' end, coalesce(u.login, ''), t.amount, t.created_at
+		from transfers t left join users u on u.id = case when t.from_user_id=$1 then t.to_user_id else t.from_user_id end
+		where t.from_user_id=$1 or t.to_user_id=$1 order by t.created_at desc, t.id desc;`, userID)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.Transfer
+	for rows.Next() {
+		var v datamodels.Transfer
+		var cents int
+		if err = rows.Scan(&v.ID, &v.Direction, &v.Counterparty, &cents, &v.ProcessedAt); err != nil {
+			return nil, ErrInternal
+		}
+		v.Sum = float64(cents) / 100
+		resp = append(resp, v)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	if resp == nil {
+		return nil, ErrNoData
+	}
+	return resp, nil
+}
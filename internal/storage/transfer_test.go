@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransfers(t *testing.T) {
+	dbs := testStorage(t)
+	ctx := context.Background()
+	a, aLogin := newUser(t, dbs)
+	b, bLogin := newUser(t, dbs)
+	credit(t, dbs, a, 10)
+	credit(t, dbs, b, 10)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		from, to := a, bLogin
+		if i%2 == 1 {
+			from, to = b, aLogin
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := dbs.Transfer(ctx, from, to, 3, 0); err != nil && !errors.Is(err, ErrNotEnoughMoney) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	balanceA, _ := balanceCents(t, dbs, a)
+	balanceB, _ := balanceCents(t, dbs, b)
+	if balanceA+balanceB != 2000 {
+		t.Errorf("balances %d + %d, want 2000 in total", balanceA, balanceB)
+	}
+	checkLedger(t, dbs, a)
+	checkLedger(t, dbs, b)
+
+	// the rejections below must come after the balance check, so the sender needs some points
+	credit(t, dbs, a, 1)
+	blocked, blockedLogin := newUser(t, dbs)
+	exec(t, dbs, "update users set blocked=true where id=$1;", blocked)
+	for _, tc := range []struct {
+		name  string
+		to    string
+		sum   float64
+		limit float64
+		want  error
+	}{
+		{"self", aLogin, 1, 0, ErrTransferToSelf},
+		{"unknown recipient beyond the balance", "no such login", 1000, 0, ErrNotEnoughMoney},
+		{"unknown recipient", "no such login", 0.01, 0, ErrTransferRejected},
+		{"blocked recipient", blockedLogin, 0.01, 0, ErrTransferRejected},
+		{"daily limit", bLogin, 0.01, 0.01, ErrTransferLimit},
+	} {
+		if _, err := dbs.Transfer(context.Background(), a, tc.to, tc.sum, tc.limit); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	checkLedger(t, dbs, a)
+}
+
+func TestTransferKeepsExpiry(t *testing.T) {
+	dbs := testStorage(t)
+	a, _ := newUser(t, dbs)
+	b, bLogin := newUser(t, dbs)
+	order := credit(t, dbs, a, 10)
+	exec(t, dbs, "update balance set expires_at = '2030-01-01T00:00:00Z' where order_id=$1;", order)
+	if _, err := dbs.Transfer(context.Background(), a, bLogin, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	var remaining int
+	var expiresAt time.Time
+	err := dbs.db.QueryRow("select remaining, expires_at from balance where user_id=$1 and kind=$2;", b, kindTransfer).Scan(&remaining, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 400 || !expiresAt.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("received credit: remaining %d, expires %v", remaining, expiresAt)
+	}
+	checkLedger(t, dbs, a)
+	checkLedger(t, dbs, b)
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	ErrInvalidTOTP      = errors.New("invalid or already used one-time code")
+)
+
+func (dbs *DBStorage) GetTwoFactor(ctx context.Context, userID int) (datamodels.TwoFactor, error) {
+	var v datamodels.TwoFactor
+	var secret sql.NullString
+	var step sql.NullInt64
+	err := dbs.db.QueryRowContext(ctx, "select totp_secret, totp_enabled, totp_last_step from users where id=$1;", userID).
+		Scan(&secret, &v.Enabled, &step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return datamodels.TwoFactor{}, ErrNotFound
+	}
+	if err != nil {
+		return datamodels.TwoFactor{}, ErrInternal
+	}
+	v.Secret, v.LastStep = secret.String, step.Int64
+	return v, nil
+}
+
+// SetTOTPSecret stores a secret awaiting confirmation; it fails once two-factor authentication is enabled.
+func (dbs *DBStorage) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	res, err := dbs.db.ExecContext(ctx, "update users set totp_secret=$2 where id=$1 and not totp_enabled;", userID, secret)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// EnableTwoFactor turns on the stored secret, consuming step, and replaces the recovery codes.
+func (dbs *DBStorage) EnableTwoFactor(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "update users set totp_enabled=true, totp_last_step=$2 where id=$1 and not totp_enabled and totp_secret is not null;", userID, step)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTwoFactorEnabled
+	}
+	if _, err = tx.ExecContext(ctx, "delete from recovery_codes where user_id=$1;", userID); err != nil {
+		return ErrInternal
+	}
+	for _, hash := range codeHashes {
+		if _, err = tx.ExecContext(ctx, "insert into recovery_codes (user_id, code_hash) values ($1, $2);", userID, hash); err != nil {
+			return ErrInternal
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+func (dbs *DBStorage) DisableTwoFactor(ctx context.Context, userID int) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "update users set totp_enabled=false, totp_secret=null, totp_last_step=null where id=$1;", userID); err != nil {
+		return ErrInternal
+	}
+	if _, err = tx.ExecContext(ctx, "delete from recovery_codes where user_id=$1;", userID); err != nil {
+		return ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// UseTOTPStep records step as used; codes of the same or earlier steps are rejected afterwards.
+func (dbs *DBStorage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	res, err := dbs.db.ExecContext(ctx, "update users set totp_last_step=$2 where id=$1 and (totp_last_step is null or totp_last_step < $2);", userID, step)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidTOTP
+	}
+	return nil
+}
+
+func (dbs *DBStorage) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	res, err := dbs.db.ExecContext(ctx, "update recovery_codes set used_at=now() where user_id=$1 and code_hash=$2 and used_at is null;", userID, codeHash)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidTOTP
+	}
+	return nil
+}
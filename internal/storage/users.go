@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrEmailTaken           = errors.New("email is used by another account")
+	ErrVerificationNotFound = errors.New("invalid or expired email verification token")
+)
+
+// UserRepository keeps user profiles and contact details apart from the points ledger in Storage.
+type UserRepository interface {
+	GetProfile(ctx context.Context, userID int) (datamodels.Profile, error)
+	UpdateProfile(ctx context.Context, userID int, patch datamodels.ProfilePatch) (datamodels.Profile, error)
+	CreateEmailVerification(ctx context.Context, userID int, email string, tokenHash string, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, tokenHash string) (int, error)
+}
+
+type dbUsers struct {
+	db *sql.DB
+}
+
+// NewUserRepository returns the repository sharing the connection pool of dbs.
+func NewUserRepository(dbs *DBStorage) UserRepository {
+	return &dbUsers{db: dbs.db}
+}
+
+// GetProfile returns the profile of an existing user; users who never saved one get the defaults.
+func (us *dbUsers) GetProfile(ctx context.Context, userID int) (datamodels.Profile, error) {
+	var v datamodels.Profile
+	var email, phone sql.NullString
+	err := us.db.QueryRowContext(ctx, `select coalesce(p.display_name, ''), p.email, coalesce(p.email_verified, false), p.phone,
+		coalesce(p.locale, ''), coalesce(p.notify_email, true), coalesce(p.notify_sms, false), coalesce(p.notify_marketing, false)
+		from users u left join user_profiles p on p.user_id = u.id where u.id=$1;`, userID).
+		Scan(&v.DisplayName, &email, &v.EmailVerified, &phone, &v.Locale, &v.Notifications.Email, &v.Notifications.SMS, &v.Notifications.Marketing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return datamodels.Profile{}, ErrNotFound
+	}
+	if err != nil {
+		return datamodels.Profile{}, ErrInternal
+	}
+	v.Email, v.Phone = email.String, phone.String
+	return v, nil
+}
+
+// UpdateProfile applies patch; a changed email becomes unverified. Addresses are unique only once
+// verified, so an unverified address may be shared and VerifyEmail settles who owns it.
+func (us *dbUsers) UpdateProfile(ctx context.Context, userID int, patch datamodels.ProfilePatch) (datamodels.Profile, error) {
+	var notifyEmail, notifySMS, notifyMarketing *bool
+	if n := patch.Notifications; n != nil {
+		notifyEmail, notifySMS, notifyMarketing = n.Email, n.SMS, n.Marketing
+	}
+	_, err := us.db.ExecContext(ctx, `insert into user_profiles as p (user_id, display_name, email, phone, locale, notify_email, notify_sms, notify_marketing)
+		values ($1, coalesce($2::text, ''), nullif($3::text, ''), nullif($4::text, ''), coalesce($5::text, ''),
+			coalesce($6::boolean, true), coalesce($7::boolean, false), coalesce($8::boolean, false))
+		on conflict (user_id) do update set
+			display_name = coalesce($2::text, p.display_name),
+			email = case when $3::text is null then p.email else nullif($3::text, '') end,
+			email_verified = case when $3::text is null or lower(nullif($3::text, '')) is not distinct from lower(p.email) then p.email_verified else false end,
+			phone = case when $4::text is null then p.phone else nullif($4::text, '') end,
+			locale = coalesce($5::text, p.locale),
+			notify_email = coalesce($6::boolean, p.notify_email),
+			notify_sms = coalesce($7::boolean, p.notify_sms),
+			notify_marketing = coalesce($8::boolean, p.notify_marketing),
+			updated_at = now();`,
+		userID, patch.DisplayName, patch.Email, patch.Phone, patch.Locale, notifyEmail, notifySMS, notifyMarketing)
+	if err != nil {
+		return datamodels.Profile{}, ErrInternal
+	}
+	return us.GetProfile(ctx, userID)
+}
+
+func (us *dbUsers) CreateEmailVerification(ctx context.Context, userID int, email string, tokenHash string, expiresAt time.Time) error {
+	_, err := us.db.ExecContext(ctx, "insert into email_verifications (user_id, email, token_hash, expires_at) values ($1, $2, $3, $4);",
+		userID, email, tokenHash, expiresAt)
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// VerifyEmail consumes a verification token and marks the email verified if it is still the profile email.
+// It returns ErrEmailTaken when another account verified the address first.
+func (us *dbUsers) VerifyEmail(ctx context.Context, tokenHash string) (int, error) {
+	tx, err := us.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, ErrInternal
+	}
+	defer tx.Rollback()
+	var userID int
+	var email string
+	err = tx.QueryRowContext(ctx, "update email_verifications set used_at=now() where token_hash=$1 and used_at is null and expires_at > now() returning user_id, email;", tokenHash).
+		Scan(&userID, &email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrVerificationNotFound
+	}
+	if err != nil {
+		return 0, ErrInternal
+	}
+	res, err := tx.ExecContext(ctx, "update user_profiles set email_verified=true, updated_at=now() where user_id=$1 and lower(email)=lower($2);", userID, email)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return 0, ErrEmailTaken
+	}
+	if err != nil {
+		return 0, ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrVerificationNotFound
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, ErrInternal
+	}
+	return userID, nil
+}
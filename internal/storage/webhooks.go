@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/lib/pq"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+func (dbs *DBStorage) CreateWebhook(ctx context.Context, endpoint datamodels.WebhookEndpoint) (datamodels.WebhookEndpoint, error) {
+	err := dbs.db.QueryRowContext(ctx, "insert into webhook_endpoints (user_id, url, secret, event_types) values ($1, $2, $3, $4) returning id, active, created_at;",
+		endpoint.UserID, endpoint.URL, endpoint.Secret, pq.Array(endpoint.EventTypes)).Scan(&endpoint.ID, &endpoint.Active, &endpoint.CreatedAt)
+	if err != nil {
+		return datamodels.WebhookEndpoint{}, ErrInternal
+	}
+	return endpoint, nil
+}
+
+func (dbs *DBStorage) GetWebhooks(ctx context.Context, userID int) ([]datamodels.WebhookEndpoint, error) {
+	rows, err := dbs.db.QueryContext(ctx, "select id, url, event_types, active, created_at from webhook_endpoints where user_id=$1 order by id;", userID)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.WebhookEndpoint
+	for rows.Next() {
+		tmp := datamodels.WebhookEndpoint{UserID: userID}
+		err = rows.Scan(&tmp.ID, &tmp.URL, pq.Array(&tmp.EventTypes), &tmp.Active, &tmp.CreatedAt)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, tmp)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	if resp == nil {
+		return nil, ErrNoData
+	}
+	return resp, nil
+}
+
+func (dbs *DBStorage) DeleteWebhook(ctx context.Context, userID int, id int) error {
+	res, err := dbs.db.ExecContext(ctx, "delete from webhook_endpoints where id=$1 and user_id=$2;", id, userID)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// GetWebhookDeliveries lists the latest deliveries of the user's endpoints, optionally filtered by status.
+func (dbs *DBStorage) GetWebhookDeliveries(ctx context.Context, userID int, status string, limit int) ([]datamodels.WebhookDelivery, error) {
+	rows, err := dbs.db.QueryContext(ctx, `select d.id, d.endpoint_id, d.event_id, d.event_type, d.status, d.attempts, d.next_attempt_at, d.last_error, d.created_at
+		from webhook_deliveries d join webhook_endpoints e on e.id = d.endpoint_id
+		where e.user_id=$1 and ($2 = '' or d.status = $2) order by d.id desc limit $3;`, userID, status, limit)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.WebhookDelivery
+	for rows.Next() {
+		var tmp datamodels.WebhookDelivery
+		err = rows.Scan(&tmp.ID, &tmp.EndpointID, &tmp.EventID, &tmp.EventType, &tmp.Status, &tmp.Attempts, &tmp.NextAttemptAt, &tmp.LastError, &tmp.CreatedAt)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, tmp)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	if resp == nil {
+		return nil, ErrNoData
+	}
+	return resp, nil
+}
+
+// ReplayWebhookDelivery schedules a delivery of the user's endpoint for immediate redelivery.
+func (dbs *DBStorage) ReplayWebhookDelivery(ctx context.Context, userID int, id int64) error {
+	res, err := dbs.db.ExecContext(ctx, `update webhook_deliveries d set status='PENDING', attempts=0, next_attempt_at=now(), last_error=''
+		from webhook_endpoints e where d.id=$1 and e.id = d.endpoint_id and e.user_id=$2;`, id, userID)
+	if err != nil {
+		return ErrInternal
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries leases due deliveries so that concurrent dispatchers do not send them twice.
+func (dbs *DBStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]datamodels.WebhookDelivery, error) {
+	rows, err := dbs.db.QueryContext(ctx, `update webhook_deliveries d set next_attempt_at = now() + make_interval(secs => $2)
+		from webhook_endpoints e
+		where e.id = d.endpoint_id and d.id in (
+			select id from webhook_deliveries where status='PENDING' and next_attempt_at <= now()
+			order by next_attempt_at limit $1 for update skip locked)
+		returning d.id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.attempts, d.created_at, e.url, e.secret;`, limit, lease.Seconds())
+	if err != nil {
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	var resp []datamodels.WebhookDelivery
+	for rows.Next() {
+		tmp := datamodels.WebhookDelivery{Status: datamodels.DeliveryPending}
+		err = rows.Scan(&tmp.ID, &tmp.EndpointID, &tmp.EventID, &tmp.EventType, &tmp.Payload, &tmp.Attempts, &tmp.CreatedAt, &tmp.URL, &tmp.Secret)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		resp = append(resp, tmp)
+	}
+	if rows.Err() != nil {
+		return nil, ErrInternal
+	}
+	return resp, nil
+}
+
+func (dbs *DBStorage) CompleteWebhookDelivery(ctx context.Context, id int64) error {
+	_, err := dbs.db.ExecContext(ctx, "update webhook_deliveries set status='DELIVERED', attempts=attempts+1, delivered_at=now(), last_error='' where id=$1;", id)
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// FailWebhookDelivery records a failed attempt; a zero retryAt moves the delivery to the dead letters.
+func (dbs *DBStorage) FailWebhookDelivery(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	next := sql.NullTime{Time: retryAt, Valid: !retryAt.IsZero()}
+	_, err := dbs.db.ExecContext(ctx, `update webhook_deliveries set attempts=attempts+1, last_error=$2,
+		status = case when $3::timestamptz is null then 'DEAD' else 'PENDING' end,
+		next_attempt_at = coalesce($3::timestamptz, next_attempt_at) where id=$1;`, id, reason, next)
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/totp"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors, "12345678901234567890", in base32.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestCodeRFC6238 checks the SHA1 vectors of RFC 6238, appendix B, cut to six digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totp.Code(rfcSecret, totp.Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	secret := totp.NewSecret()
+	upper, err := totp.Code(secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := totp.Code(strings.ToLower(secret), 1)
+	if err != nil || lower != upper {
+		t.Errorf("lowercase secret: %q, %v; want %q", lower, err, upper)
+	}
+	if _, err = totp.Code("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := totp.Step(now)
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, err := totp.Code(rfcSecret, step+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := totp.Validate(rfcSecret, code, now)
+		want := offset >= -totp.Skew && offset <= totp.Skew
+		if ok != want || ok && got != step+offset {
+			t.Errorf("code of step %+d: step %d, ok %v; want ok %v", offset, got, ok, want)
+		}
+	}
+	for _, code := range []string{"", "00592", "0059240", "abcdef"} {
+		if _, ok := totp.Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(totp.URI("Gophermart", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Gophermart:alice@example.com" {
+		t.Errorf("URI %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "Gophermart" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("URI parameters %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes := totp.NewRecoveryCodes(10)
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("recovery code %q is not xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q repeated", code)
+		}
+		seen[code] = true
+	}
+	hash := totp.HashRecoveryCode(codes[0])
+	for _, typed := range []string{" " + codes[0] + " ", codes[0][:5] + codes[0][6:], strings.ToUpper(codes[0])} {
+		if totp.HashRecoveryCode(typed) != hash {
+			t.Errorf("%q hashes differently from %q", typed, codes[0])
+		}
+	}
+	if totp.HashRecoveryCode(codes[1]) == hash {
+		t.Error("different codes share a hash")
+	}
+}
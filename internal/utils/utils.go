@@ -3,25 +3,11 @@ package utils
 import (
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base32"
 	"encoding/hex"
 )
 
-func Checksum(number int) int {
-	var luhn int
-	for i := 0; number > 0; i++ {
-		cur := number % 10
-		if i%2 != 0 { // even
-			cur = cur * 2
-			if cur > 9 {
-				cur -= 9
-			}
-		}
-		luhn += cur
-		number = number / 10
-	}
-	return luhn % 10
-}
 func GenerateRandomString(len int) string {
 	b := make([]byte, len)
 	rand.Read(b)
@@ -31,3 +17,7 @@ func GetMD5Hash(text string) string {
 	hash := md5.Sum([]byte(text))
 	return hex.EncodeToString(hash[:])
 }
+func GetSHA256Hash(text string) string {
+	hash := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,137 @@
+package validation
+
+import (
+	"math"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/N0rkton/gophermart/internal/apierror"
+	"github.com/N0rkton/gophermart/internal/luhn"
+)
+
+const (
+	MinLoginLen     = 3
+	MaxLoginLen     = 255
+	MinPasswordLen  = 6
+	MaxPasswordLen  = 128
+	MaxOrderLen     = 255
+	MaxSumPrecision = 2
+	MaxSum          = 10_000_000
+	MaxEmailLen     = 320
+	MaxNameLen      = 100
+)
+
+var (
+	phonePattern  = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+)
+
+var (
+	ErrFailed        = apierror.New(http.StatusBadRequest, "validation_failed", "request validation failed")
+	ErrOrderChecksum = apierror.New(http.StatusUnprocessableEntity, "invalid_order_number", "invalid order number")
+)
+
+// Validator collects field errors of a single request.
+type Validator struct {
+	fields []apierror.FieldError
+}
+
+func New() *Validator {
+	return &Validator{}
+}
+
+// Check adds a field error when ok is false.
+func (v *Validator) Check(ok bool, field string, code string, message string) {
+	if !ok {
+		v.fields = append(v.fields, apierror.FieldError{Field: field, Code: code, Message: message})
+	}
+}
+
+// Err returns nil when every check passed and ErrFailed with the field details otherwise.
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return ErrFailed.WithFields(v.fields...)
+}
+
+func (v *Validator) Login(field string, login string) {
+	n := utf8.RuneCountInString(login)
+	v.Check(n >= MinLoginLen && n <= MaxLoginLen, field, "length", "login must be 3 to 255 characters long")
+	v.Check(printable(login), field, "charset", "login must not contain spaces or control characters")
+}
+
+// KnownLogin checks a login given to sign in or reset a password. It only bounds the length, so
+// logins registered under older rules still work, and keeps overlong input away from storage.
+func (v *Validator) KnownLogin(field string, login string) {
+	n := utf8.RuneCountInString(login)
+	v.Check(n > 0 && n <= MaxLoginLen, field, "length", "login must be 1 to 255 characters long")
+}
+
+func (v *Validator) Password(field string, password string) {
+	n := utf8.RuneCountInString(password)
+	v.Check(n >= MinPasswordLen && n <= MaxPasswordLen, field, "length", "password must be 6 to 128 characters long")
+}
+
+func (v *Validator) Email(field string, email string) {
+	addr, err := mail.ParseAddress(email)
+	v.Check(err == nil && addr.Address == email && len(email) <= MaxEmailLen, field, "email", "must be a valid email address")
+}
+
+// Phone checks an E.164 phone number such as +14155550100.
+func (v *Validator) Phone(field string, phone string) {
+	v.Check(phonePattern.MatchString(phone), field, "phone", "phone must be in international format, e.g. +14155550100")
+}
+
+// Locale checks a language tag such as en or ru-RU.
+func (v *Validator) Locale(field string, locale string) {
+	v.Check(localePattern.MatchString(locale), field, "locale", "locale must be a language tag such as en or ru-RU")
+}
+
+func (v *Validator) DisplayName(field string, name string) {
+	v.Check(utf8.RuneCountInString(name) <= MaxNameLen, field, "length", "display name must be at most 100 characters long")
+	v.Check(!strings.ContainsFunc(name, unicode.IsControl), field, "charset", "display name must not contain control characters")
+}
+
+func (v *Validator) Sum(field string, sum float64) {
+	v.Check(sum > 0 && !math.IsInf(sum, 0), field, "positive", "sum must be greater than zero")
+	// amounts are stored as int4 cents
+	v.Check(sum <= MaxSum, field, "range", "sum must be at most 10000000")
+	cents := sum * math.Pow10(MaxSumPrecision)
+	v.Check(math.Abs(cents-math.Round(cents)) < 1e-6, field, "precision", "sum must have at most two decimal places")
+}
+
+// OrderNumber checks the format of an order number; a wrong check digit is reported separately with status 422.
+func (v *Validator) OrderNumber(field string, number string) error {
+	ok := number != "" && len(number) <= MaxOrderLen && digits(number)
+	v.Check(ok, field, "format", "order number must be a non-empty string of digits")
+	if !ok {
+		return v.Err()
+	}
+	if !luhn.Valid(number) {
+		return ErrOrderChecksum.WithFields(apierror.FieldError{Field: field, Code: "checksum", Message: "order number fails the Luhn check"})
+	}
+	return nil
+}
+
+func printable(s string) bool {
+	for _, c := range s {
+		if unicode.IsSpace(c) || unicode.IsControl(c) || c == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
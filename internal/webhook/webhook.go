@@ -0,0 +1,194 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/logger"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Gophermart-Event"
+	HeaderDelivery  = "X-Gophermart-Delivery"
+	HeaderTimestamp = "X-Gophermart-Timestamp"
+	HeaderSignature = "X-Gophermart-Signature"
+)
+
+var log = logger.For("webhook")
+
+// Store is the part of the storage the dispatcher works with.
+type Store interface {
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]datamodels.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, id int64) error
+	FailWebhookDelivery(ctx context.Context, id int64, reason string, retryAt time.Time) error
+}
+
+// Envelope is the JSON body posted to an endpoint.
+type Envelope struct {
+	ID        int64           `json:"id"`
+	EventID   int64           `json:"event_id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign computes the signature of a delivery: hex HMAC-SHA256 over "<timestamp>.<body>", prefixed with "sha256=".
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign in constant time.
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+type Config struct {
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+	Timeout      time.Duration
+	// AllowPrivate lets deliveries reach loopback and private addresses, for local testing only.
+	AllowPrivate bool
+}
+
+var DefaultConfig = Config{
+	MaxAttempts:  8,
+	BaseDelay:    5 * time.Second,
+	MaxDelay:     time.Hour,
+	PollInterval: time.Second,
+	BatchSize:    50,
+	Timeout:      10 * time.Second,
+}
+
+// Dispatcher delivers queued webhook events with retries and exponential backoff.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	cfg    Config
+}
+
+func NewDispatcher(store Store, cfg Config) *Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !cfg.AllowPrivate {
+		transport.Proxy = nil
+		transport.DialContext = publicDialer(cfg.Timeout).DialContext
+	}
+	return &Dispatcher{
+		store:  store,
+		client: &http.Client{Timeout: cfg.Timeout, Transport: otelhttp.NewTransport(transport)},
+		cfg:    cfg,
+	}
+}
+
+// Run delivers due webhooks until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatch(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	// the batch is delivered concurrently, so the lease only has to outlast a single delivery
+	lease := d.cfg.Timeout + d.cfg.PollInterval
+	deliveries, err := d.store.ClaimWebhookDeliveries(ctx, d.cfg.BatchSize, lease)
+	if err != nil {
+		log.ErrorContext(ctx, "claim webhook deliveries", "err", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery datamodels.WebhookDelivery) {
+			defer wg.Done()
+			d.process(ctx, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// process delivers one claimed event and records the outcome.
+func (d *Dispatcher) process(ctx context.Context, delivery datamodels.WebhookDelivery) {
+	err := d.deliver(ctx, delivery)
+	if err == nil {
+		err = d.store.CompleteWebhookDelivery(ctx, delivery.ID)
+	} else {
+		log.WarnContext(ctx, "webhook delivery failed", "delivery", delivery.ID, "attempt", delivery.Attempts+1, "err", err)
+		err = d.store.FailWebhookDelivery(ctx, delivery.ID, err.Error(), d.retryAt(delivery.Attempts+1))
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "update webhook delivery", "delivery", delivery.ID, "err", err)
+	}
+}
+
+// retryAt returns when the next attempt is due, or zero time once the delivery is dead.
+func (d *Dispatcher) retryAt(attempts int) time.Time {
+	if attempts >= d.cfg.MaxAttempts {
+		return time.Time{}
+	}
+	delay := d.cfg.BaseDelay << (attempts - 1)
+	if delay <= 0 || delay > d.cfg.MaxDelay {
+		delay = d.cfg.MaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return time.Now().Add(delay + jitter)
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery datamodels.WebhookDelivery) error {
+	body, err := json.Marshal(Envelope{
+		ID:        delivery.ID,
+		EventID:   delivery.EventID,
+		Type:      delivery.EventType,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
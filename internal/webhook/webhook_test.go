@@ -0,0 +1,188 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/datamodels"
+	"github.com/N0rkton/gophermart/internal/webhook"
+	"github.com/N0rkton/gophermart/internal/webhook/webhooktest"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		if got := webhook.PublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://93.184.216.34/hook", nil},
+		{"http://127.0.0.1:8080/hook", webhook.ErrForbiddenAddress},
+		{"http://169.254.169.254/latest/meta-data", webhook.ErrForbiddenAddress},
+		{"http://10.0.0.5/hook", webhook.ErrForbiddenAddress},
+		{"http://[::1]/hook", webhook.ErrForbiddenAddress},
+		{"http://localhost/hook", webhook.ErrForbiddenAddress},
+		{"ftp://93.184.216.34/hook", webhook.ErrInvalidURL},
+		{"/relative", webhook.ErrInvalidURL},
+		{"http://", webhook.ErrInvalidURL},
+	}
+	for _, tt := range tests {
+		if err := webhook.CheckURL(context.Background(), tt.url); !errors.Is(err, tt.want) {
+			t.Errorf("CheckURL(%q) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+// fakeStore hands out its pending deliveries once and records their outcome.
+type fakeStore struct {
+	mu        sync.Mutex
+	pending   []datamodels.WebhookDelivery
+	lease     time.Duration
+	completed []int64
+	failed    []string
+	done      chan struct{}
+}
+
+func (s *fakeStore) ClaimWebhookDeliveries(_ context.Context, _ int, lease time.Duration) ([]datamodels.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) > 0 {
+		s.lease = lease
+	}
+	resp := s.pending
+	s.pending = nil
+	return resp, nil
+}
+
+func (s *fakeStore) CompleteWebhookDelivery(_ context.Context, id int64) error {
+	s.mu.Lock()
+	s.completed = append(s.completed, id)
+	s.mu.Unlock()
+	s.done <- struct{}{}
+	return nil
+}
+
+func (s *fakeStore) FailWebhookDelivery(_ context.Context, _ int64, reason string, _ time.Time) error {
+	s.mu.Lock()
+	s.failed = append(s.failed, reason)
+	s.mu.Unlock()
+	s.done <- struct{}{}
+	return nil
+}
+
+func deliverOnce(t *testing.T, cfg webhook.Config, url string, secret string) *fakeStore {
+	t.Helper()
+	store := &fakeStore{done: make(chan struct{}, 1), pending: []datamodels.WebhookDelivery{{
+		ID: 1, EventID: 7, EventType: datamodels.EventWithdrawalPosted, Payload: []byte(`{"order":"79927398713"}`),
+		CreatedAt: time.Now(), URL: url, Secret: secret,
+	}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhook.NewDispatcher(store, cfg).Run(ctx)
+	select {
+	case <-store.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery was not attempted")
+	}
+	return store
+}
+
+func TestDispatcherRefusesPrivateAddresses(t *testing.T) {
+	rcv := webhooktest.NewReceiver("secret")
+	defer rcv.Close()
+	cfg := webhook.DefaultConfig
+	cfg.PollInterval = 10 * time.Millisecond
+	store := deliverOnce(t, cfg, rcv.URL, "secret")
+	if len(store.failed) != 1 || !strings.Contains(store.failed[0], webhook.ErrForbiddenAddress.Error()) {
+		t.Fatalf("failed = %q, want a forbidden address error", store.failed)
+	}
+	if n := len(rcv.Deliveries()); n != 0 {
+		t.Fatalf("receiver got %d deliveries, want none", n)
+	}
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	rcv := webhooktest.NewReceiver("secret")
+	defer rcv.Close()
+	cfg := webhook.DefaultConfig
+	cfg.PollInterval = 10 * time.Millisecond
+	cfg.AllowPrivate = true
+	store := deliverOnce(t, cfg, rcv.URL, "secret")
+	if len(store.completed) != 1 {
+		t.Fatalf("completed = %v, failed = %q", store.completed, store.failed)
+	}
+	got := rcv.Deliveries()
+	if len(got) != 1 || !got[0].Verified || got[0].Envelope.EventID != 7 {
+		t.Fatalf("deliveries = %+v, want one verified delivery of event 7", got)
+	}
+}
+
+func TestDispatcherDeliversBatchWithinLease(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer srv.Close()
+	cfg := webhook.DefaultConfig
+	cfg.PollInterval = 10 * time.Millisecond
+	cfg.Timeout = time.Second
+	cfg.AllowPrivate = true
+	const n = 10
+	store := &fakeStore{done: make(chan struct{}, n)}
+	for i := 1; i <= n; i++ {
+		store.pending = append(store.pending, datamodels.WebhookDelivery{ID: int64(i), EventType: datamodels.EventWithdrawalPosted,
+			Payload: []byte(`{}`), CreatedAt: time.Now(), URL: srv.URL, Secret: "secret"})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go webhook.NewDispatcher(store, cfg).Run(ctx)
+	for i := 0; i < n; i++ {
+		select {
+		case <-store.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("batch was not delivered")
+		}
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	// one slow endpoint per delivery must not push the end of the batch past the claim
+	if elapsed := time.Since(start); elapsed > store.lease {
+		t.Errorf("batch took %v, lease was %v", elapsed, store.lease)
+	}
+	if len(store.completed) != n {
+		t.Errorf("completed = %v, failed = %q", store.completed, store.failed)
+	}
+}
@@ -0,0 +1,97 @@
+// Package webhooktest provides a local webhook receiver for integration tests.
+package webhooktest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/N0rkton/gophermart/internal/webhook"
+)
+
+// Delivery is a request received by the Receiver.
+type Delivery struct {
+	Header   http.Header
+	Body     []byte
+	Envelope webhook.Envelope
+	Verified bool
+}
+
+// Receiver is an HTTP server recording webhook deliveries and checking their signatures.
+type Receiver struct {
+	*httptest.Server
+	secret string
+
+	mu         sync.Mutex
+	deliveries []Delivery
+	failures   int
+	arrived    chan struct{}
+}
+
+// NewReceiver starts a receiver verifying signatures with secret; call Close when done.
+func NewReceiver(secret string) *Receiver {
+	rcv := &Receiver{secret: secret, arrived: make(chan struct{}, 1)}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(rcv.serve))
+	return rcv
+}
+
+// SetSecret changes the secret used to verify signatures, e.g. once the endpoint has been registered.
+func (rcv *Receiver) SetSecret(secret string) {
+	rcv.mu.Lock()
+	rcv.secret = secret
+	rcv.mu.Unlock()
+}
+
+// FailNext makes the next n deliveries fail with 503 to exercise retries.
+func (rcv *Receiver) FailNext(n int) {
+	rcv.mu.Lock()
+	rcv.failures = n
+	rcv.mu.Unlock()
+}
+
+func (rcv *Receiver) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	if rcv.failures > 0 {
+		rcv.failures--
+		rcv.mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	d := Delivery{
+		Header:   r.Header.Clone(),
+		Body:     body,
+		Verified: webhook.Verify(rcv.secret, r.Header.Get(webhook.HeaderTimestamp), body, r.Header.Get(webhook.HeaderSignature)),
+	}
+	json.Unmarshal(body, &d.Envelope)
+	rcv.deliveries = append(rcv.deliveries, d)
+	rcv.mu.Unlock()
+	select {
+	case rcv.arrived <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries returns the successful deliveries received so far.
+func (rcv *Receiver) Deliveries() []Delivery {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]Delivery(nil), rcv.deliveries...)
+}
+
+// Wait blocks until at least n deliveries arrived or timeout passes and reports whether they did.
+func (rcv *Receiver) Wait(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for len(rcv.Deliveries()) < n {
+		select {
+		case <-rcv.arrived:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}